@@ -51,6 +51,13 @@ type Exponential struct {
 
 // Wait waits for the required time using an exponential backoff
 func (b *Exponential) Wait() {
+	time.Sleep(b.Duration())
+}
+
+// Duration returns the time to wait before the next attempt and counts the
+// attempt. It allows callers to wait for the required time themselves, e.g.
+// to stop waiting early.
+func (b *Exponential) Duration() time.Duration {
 	b.attempt++
 
 	if b.Name == "" {
@@ -79,5 +86,5 @@ func (b *Exponential) Wait() {
 		"name":    b.Name,
 	}).Debug("Sleeping with exponential backoff")
 
-	time.Sleep(t)
+	return t
 }
@@ -0,0 +1,137 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/lock"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// routeCache caches the routes installed in the kernel keyed by prefix and
+// routing table. It allows ReplaceRoute to skip the kernel round-trip if the
+// desired route is known to be installed already.
+type routeCache struct {
+	mutex  lock.RWMutex
+	routes map[string][]Route
+}
+
+// installedRoutes is the cache of routes installed in the host network
+// namespace. It remains empty and thus unused until RefreshCache is called.
+var installedRoutes = newRouteCache()
+
+func newRouteCache() *routeCache {
+	return &routeCache{routes: map[string][]Route{}}
+}
+
+// cacheKey returns the key of a route with the specified prefix in the
+// specified routing table
+func cacheKey(prefix net.IPNet, table int) string {
+	return fmt.Sprintf("%s@%d", prefix.String(), getTable(table))
+}
+
+// contains returns true if a cached route matches the specified route, see
+// Route.Matches()
+func (rc *routeCache) contains(route *Route) bool {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+
+	cached := rc.routes[cacheKey(route.Prefix, route.Table)]
+	for i := range cached {
+		if route.Matches(&cached[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invalidate removes all cached routes with the specified prefix and table
+func (rc *routeCache) invalidate(prefix net.IPNet, table int) {
+	rc.mutex.Lock()
+	delete(rc.routes, cacheKey(prefix, table))
+	rc.mutex.Unlock()
+}
+
+// update invalidates the cached routes affected by a route change reported by
+// a route subscription. Routes added, changed or removed by other means than
+// this package are thus looked up in the kernel again.
+func (rc *routeCache) update(nlRoute *netlink.Route) {
+	route := newRouteFromNetlink("", nlRoute)
+	rc.invalidate(route.Prefix, route.Table)
+}
+
+// set replaces all cached routes of the specified device with routes
+func (rc *routeCache) set(device string, routes []Route) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for key, cached := range rc.routes {
+		remaining := cached[:0]
+		for _, r := range cached {
+			if r.Device != device {
+				remaining = append(remaining, r)
+			}
+		}
+
+		if len(remaining) == 0 {
+			delete(rc.routes, key)
+		} else {
+			rc.routes[key] = remaining
+		}
+	}
+
+	for _, r := range routes {
+		key := cacheKey(r.Prefix, r.Table)
+		rc.routes[key] = append(rc.routes[key], r)
+	}
+}
+
+// RefreshCache reads all routes of all routing tables pointing to the
+// specified device from the kernel and replaces the cached routes of the
+// device with them. Once populated, ReplaceRoute skips routes which are
+// already cached. Routes modified by other means than this package are only
+// noticed while a route subscription is active, see SubscribeRouteChanges and
+// NewRouteWatcher, or when the cache is refreshed again.
+func RefreshCache(device string) error {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return fmt.Errorf("unable to lookup interface %s: %s", device, err)
+	}
+
+	filter := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Table:     unix.RT_TABLE_UNSPEC,
+	}
+
+	nlRoutes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, filter,
+		netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return fmt.Errorf("unable to list routes of interface %s: %s", device, err)
+	}
+
+	routes := make([]Route, 0, len(nlRoutes))
+	for i := range nlRoutes {
+		routes = append(routes, newRouteFromNetlink(device, &nlRoutes[i]))
+	}
+
+	installedRoutes.set(device, routes)
+
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+)
+
+func (p *RouteSuite) TestRouteCache(c *C) {
+	_, prefix1, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("10.1.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt1 := Route{Device: "eth0", Prefix: *prefix1, Nexthop: parseIP("192.168.0.1")}
+	rt2 := Route{Device: "eth1", Prefix: *prefix2, Nexthop: parseIP("192.168.1.1")}
+
+	rc := newRouteCache()
+	c.Assert(rc.contains(&rt1), Equals, false)
+
+	// Routes read from the kernel carry the main table explicitly
+	cached := rt1
+	cached.Table = unix.RT_TABLE_MAIN
+	rc.set("eth0", []Route{cached})
+	rc.set("eth1", []Route{rt2})
+	c.Assert(rc.contains(&rt1), Equals, true)
+	c.Assert(rc.contains(&rt2), Equals, true)
+
+	// A different nexthop must not match
+	other := rt1
+	other.Nexthop = parseIP("192.168.0.2")
+	c.Assert(rc.contains(&other), Equals, false)
+
+	// A different table must not match
+	other = rt1
+	other.Table = 200
+	c.Assert(rc.contains(&other), Equals, false)
+
+	rc.invalidate(rt1.Prefix, rt1.Table)
+	c.Assert(rc.contains(&rt1), Equals, false)
+	c.Assert(rc.contains(&rt2), Equals, true)
+
+	// Refreshing a device replaces only the routes of that device
+	rc.set("eth0", []Route{rt1})
+	rc.set("eth1", []Route{})
+	c.Assert(rc.contains(&rt1), Equals, true)
+	c.Assert(rc.contains(&rt2), Equals, false)
+
+	// A route change reported by a subscription invalidates the route
+	nlRoute := rt1.getNetlinkRoute()
+	rc.update(&nlRoute)
+	c.Assert(rc.contains(&rt1), Equals, false)
+
+	// Routes without an L2 nexthop route are complete if cached, the
+	// nexthop route of other routes must be verified
+	blackhole := Route{Prefix: *prefix1, Type: unix.RTN_BLACKHOLE}
+	c.Assert(hasNexthop(defaultHandle, &blackhole), Equals, true)
+	missing := Route{Device: "nonexistent-dev", Prefix: *prefix1, Nexthop: parseIP("192.168.0.1")}
+	c.Assert(hasNexthop(defaultHandle, &missing), Equals, false)
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// routeJSON is the JSON representation of a Route. Addresses and prefixes
+// are rendered as strings, the scope as its numeric value.
+type routeJSON struct {
+	Prefix         string        `json:"prefix"`
+	Nexthop        string        `json:"nexthop,omitempty"`
+	Local          string        `json:"local,omitempty"`
+	Device         string        `json:"device,omitempty"`
+	MTU            int           `json:"mtu,omitempty"`
+	MTUExplicit    bool          `json:"mtuExplicit,omitempty"`
+	Scope          int           `json:"scope,omitempty"`
+	Table          int           `json:"table,omitempty"`
+	Priority       int           `json:"priority,omitempty"`
+	Proto          int           `json:"proto,omitempty"`
+	Type           int           `json:"type,omitempty"`
+	Nexthops       []nexthopJSON `json:"nexthops,omitempty"`
+	Onlink         bool          `json:"onlink,omitempty"`
+	Tos            int           `json:"tos,omitempty"`
+	AdvMSS         int           `json:"advmss,omitempty"`
+	AdvMSSOverhead int           `json:"advmssOverhead,omitempty"`
+	LinkIndex      int           `json:"linkIndex,omitempty"`
+	FromPrefix     string        `json:"fromPrefix,omitempty"`
+	MPLSLabels     []int         `json:"mplsLabels,omitempty"`
+	NexthopMAC     string        `json:"nexthopMAC,omitempty"`
+	NexthopScope   int           `json:"nexthopScope,omitempty"`
+	StrictSrc      bool          `json:"strictSrc,omitempty"`
+}
+
+// nexthopJSON is the JSON representation of a NexthopInfo
+type nexthopJSON struct {
+	IP     string `json:"ip"`
+	Device string `json:"device,omitempty"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// MarshalJSON returns the JSON representation of the route. Only MPLS
+// encapsulation is supported, routes with other encapsulations cannot be
+// marshalled.
+func (r Route) MarshalJSON() ([]byte, error) {
+	rj := routeJSON{
+		Device:         r.Device,
+		MTU:            r.MTU,
+		MTUExplicit:    r.MTUExplicit,
+		Scope:          int(r.Scope),
+		Table:          r.Table,
+		Priority:       r.Priority,
+		Proto:          r.Proto,
+		Type:           r.Type,
+		Onlink:         r.Onlink,
+		Tos:            r.Tos,
+		AdvMSS:         r.AdvMSS,
+		AdvMSSOverhead: r.AdvMSSOverhead,
+		LinkIndex:      r.LinkIndex,
+		NexthopScope:   int(r.NexthopScope),
+		StrictSrc:      r.StrictSrc,
+	}
+
+	if r.Prefix.IP != nil {
+		rj.Prefix = r.Prefix.String()
+	}
+	if r.Nexthop != nil {
+		rj.Nexthop = r.Nexthop.String()
+	}
+	if r.Local != nil {
+		rj.Local = r.Local.String()
+	}
+	if r.FromPrefix != nil {
+		rj.FromPrefix = r.FromPrefix.String()
+	}
+	if r.NexthopMAC != nil {
+		rj.NexthopMAC = r.NexthopMAC.String()
+	}
+
+	for _, nh := range r.Nexthops {
+		rj.Nexthops = append(rj.Nexthops, nexthopJSON{
+			IP:     nh.IP.String(),
+			Device: nh.Device,
+			Weight: nh.Weight,
+		})
+	}
+
+	switch encap := r.Encap.(type) {
+	case nil:
+	case *netlink.MPLSEncap:
+		rj.MPLSLabels = encap.Labels
+	default:
+		return nil, fmt.Errorf("unsupported encapsulation %s", r.Encap)
+	}
+
+	return json.Marshal(rj)
+}
+
+// parseJSONIP parses s as IP address and returns an error naming the field
+// if s is not a valid IP address
+func parseJSONIP(field, s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid %s IP address %q", field, s)
+	}
+
+	return ip, nil
+}
+
+// UnmarshalJSON parses the JSON representation of a route as returned by
+// MarshalJSON
+func (r *Route) UnmarshalJSON(data []byte) error {
+	var rj routeJSON
+	if err := json.Unmarshal(data, &rj); err != nil {
+		return err
+	}
+
+	route := Route{
+		Device:         rj.Device,
+		MTU:            rj.MTU,
+		MTUExplicit:    rj.MTUExplicit,
+		Scope:          netlink.Scope(rj.Scope),
+		Table:          rj.Table,
+		Priority:       rj.Priority,
+		Proto:          rj.Proto,
+		Type:           rj.Type,
+		Onlink:         rj.Onlink,
+		Tos:            rj.Tos,
+		AdvMSS:         rj.AdvMSS,
+		AdvMSSOverhead: rj.AdvMSSOverhead,
+		LinkIndex:      rj.LinkIndex,
+		NexthopScope:   netlink.Scope(rj.NexthopScope),
+		StrictSrc:      rj.StrictSrc,
+	}
+
+	if rj.Prefix != "" {
+		_, prefix, err := net.ParseCIDR(rj.Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %q: %s", rj.Prefix, err)
+		}
+		route.Prefix = *prefix
+	}
+
+	if rj.Nexthop != "" {
+		nexthop, err := parseJSONIP("nexthop", rj.Nexthop)
+		if err != nil {
+			return err
+		}
+		route.Nexthop = &nexthop
+	}
+
+	if rj.Local != "" {
+		local, err := parseJSONIP("local", rj.Local)
+		if err != nil {
+			return err
+		}
+		route.Local = local
+	}
+
+	if rj.FromPrefix != "" {
+		_, fromPrefix, err := net.ParseCIDR(rj.FromPrefix)
+		if err != nil {
+			return fmt.Errorf("invalid source prefix %q: %s", rj.FromPrefix, err)
+		}
+		route.FromPrefix = fromPrefix
+	}
+
+	if rj.NexthopMAC != "" {
+		mac, err := net.ParseMAC(rj.NexthopMAC)
+		if err != nil {
+			return fmt.Errorf("invalid nexthop MAC address %q: %s", rj.NexthopMAC, err)
+		}
+		route.NexthopMAC = mac
+	}
+
+	for _, nh := range rj.Nexthops {
+		ip, err := parseJSONIP("nexthop", nh.IP)
+		if err != nil {
+			return err
+		}
+		route.Nexthops = append(route.Nexthops, NexthopInfo{
+			IP:     ip,
+			Device: nh.Device,
+			Weight: nh.Weight,
+		})
+	}
+
+	if len(rj.MPLSLabels) > 0 {
+		route.Encap = &netlink.MPLSEncap{Labels: rj.MPLSLabels}
+	}
+
+	*r = route
+
+	return nil
+}
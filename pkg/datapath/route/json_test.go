@@ -0,0 +1,77 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	. "gopkg.in/check.v1"
+)
+
+func (p *RouteSuite) TestMarshalJSON(c *C) {
+	nexthop := net.ParseIP("10.0.0.1")
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0").To4(),
+			Mask: net.CIDRMask(24, 32),
+		},
+		Nexthop: &nexthop,
+		Device:  "cilium_host",
+		MTU:     1450,
+		Scope:   netlink.SCOPE_LINK,
+		Table:   200,
+	}
+
+	data, err := json.Marshal(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"prefix":"10.0.0.0/24","nexthop":"10.0.0.1","device":"cilium_host","mtu":1450,"scope":253,"table":200}`)
+
+	var parsed Route
+	c.Assert(json.Unmarshal(data, &parsed), IsNil)
+	c.Assert(parsed.Equal(&r), Equals, true)
+	c.Assert(parsed.MTU, Equals, 1450)
+	c.Assert(parsed.String(), Equals, r.String())
+
+	r = Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("f00d::"),
+			Mask: net.CIDRMask(64, 128),
+		},
+		Local:  net.ParseIP("f00d::1"),
+		Device: "eth0",
+		Nexthops: []NexthopInfo{
+			{IP: net.ParseIP("f00d::2")},
+			{IP: net.ParseIP("f00d::3"), Device: "eth1", Weight: 3},
+		},
+		Encap: &netlink.MPLSEncap{Labels: []int{100, 200}},
+	}
+
+	data, err = json.Marshal(&r)
+	c.Assert(err, IsNil)
+
+	parsed = Route{}
+	c.Assert(json.Unmarshal(data, &parsed), IsNil)
+	c.Assert(parsed.Local.Equal(r.Local), Equals, true)
+	c.Assert(parsed.Nexthops, HasLen, 2)
+	c.Assert(parsed.Nexthops[1].Device, Equals, "eth1")
+	c.Assert(parsed.Nexthops[1].Weight, Equals, 3)
+	c.Assert(encapEqual(parsed.Encap, r.Encap), Equals, true)
+	c.Assert(parsed.String(), Equals, r.String())
+
+	c.Assert(json.Unmarshal([]byte(`{"prefix":"10.0.0.0"}`), &parsed), Not(IsNil))
+	c.Assert(json.Unmarshal([]byte(`{"prefix":"10.0.0.0/8","nexthop":"foo"}`), &parsed), Not(IsNil))
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// RouteManager operates on the routes of the host network namespace through a
+// single netlink handle. Unlike the package level functions, which open a
+// new netlink socket for every request, the sockets of the handle are reused
+// across all operations. This considerably speeds up the installation of a
+// large number of routes. A RouteManager must not be used after Close() has
+// been called.
+type RouteManager struct {
+	handle *netlink.Handle
+}
+
+// NewRouteManager returns a new RouteManager. The caller must release it with
+// Close().
+func NewRouteManager() (*RouteManager, error) {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create netlink handle: %s", err)
+	}
+
+	return &RouteManager{handle: h}, nil
+}
+
+// Close releases the netlink sockets of the manager
+func (m *RouteManager) Close() {
+	m.handle.Delete()
+}
+
+// ReplaceRoute adds or replaces the specified route if necessary like the
+// package level ReplaceRoute
+func (m *RouteManager) ReplaceRoute(route Route) error {
+	replaced, err := replaceRoute(m.handle, route)
+	if err != nil {
+		route.getLogger().WithError(err).Error("Unable to add route")
+		return err
+	} else if replaced {
+		// The handle operates on the host network namespace, the cache
+		// must not report the previous state of the route
+		installedRoutes.invalidate(route.Prefix, route.Table)
+		route.getLogger().Info("Updated route")
+	}
+
+	return nil
+}
+
+// DeleteRoute removes a route like the package level DeleteRoute. Deleting a
+// route which does not exist is not considered an error.
+func (m *RouteManager) DeleteRoute(route Route) error {
+	installedRoutes.invalidate(route.Prefix, route.Table)
+
+	if err := deleteRoute(m.handle, route); err != nil {
+		if isNotFound(err) {
+			route.getLogger().Debug("Route to delete does not exist")
+			return nil
+		}
+
+		route.getLogger().WithError(err).Error("Unable to delete route")
+		return err
+	}
+
+	route.getLogger().Info("Deleted route")
+
+	return nil
+}
+
+// ListRoutes returns all routes of the specified address family which point to
+// the specified device like the package level ListRoutes
+func (m *RouteManager) ListRoutes(device string, family int) ([]Route, error) {
+	return listRoutes(m.handle, device, family)
+}
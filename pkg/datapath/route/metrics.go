@@ -0,0 +1,83 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/spanstat"
+
+	"github.com/sirupsen/logrus"
+)
+
+// routeStatistics measures the duration of the individual phases of a route
+// operation
+type routeStatistics struct {
+	linkLookup   spanstat.SpanStat
+	nexthopRoute spanstat.SpanStat
+	routeLookup  spanstat.SpanStat
+	routeUpdate  spanstat.SpanStat
+}
+
+// GetMap returns a map where the key is the stats name and the value is the
+// duration of the stat.
+func (s *routeStatistics) GetMap() map[string]time.Duration {
+	return map[string]time.Duration{
+		"linkLookup":   s.linkLookup.Total(),
+		"nexthopRoute": s.nexthopRoute.Total(),
+		"routeLookup":  s.routeLookup.Total(),
+		"routeUpdate":  s.routeUpdate.Total(),
+	}
+}
+
+var (
+	statsMutex       lock.Mutex
+	lastReplaceStats = &routeStatistics{}
+	lastDeleteStats  = &routeStatistics{}
+)
+
+// record logs the statistics of the route operation and stores them as the
+// statistics of the last operation in last
+func (s *routeStatistics) record(last **routeStatistics, route Route, operation string) {
+	// Building the log fields is only worth it if they are actually logged
+	if log.Logger.Level >= logrus.DebugLevel {
+		fields := logrus.Fields{"operation": operation}
+		for name, duration := range s.GetMap() {
+			fields[name] = duration
+		}
+		route.getLogger().WithFields(fields).Debug("Route operation statistics")
+	}
+
+	statsMutex.Lock()
+	*last = s
+	statsMutex.Unlock()
+}
+
+// GetReplaceStatistics returns the duration of the individual phases of the
+// last route replacement
+func GetReplaceStatistics() map[string]time.Duration {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	return lastReplaceStats.GetMap()
+}
+
+// GetDeleteStatistics returns the duration of the individual phases of the
+// last route deletion
+func GetDeleteStatistics() map[string]time.Duration {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	return lastDeleteStats.GetMap()
+}
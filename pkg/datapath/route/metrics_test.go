@@ -0,0 +1,36 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (p *RouteSuite) TestRouteStatistics(c *C) {
+	stats := &routeStatistics{}
+	stats.linkLookup.Start()
+	time.Sleep(time.Millisecond)
+	stats.linkLookup.End()
+
+	m := stats.GetMap()
+	c.Assert(len(m), Equals, 4)
+	c.Assert(m["linkLookup"] >= time.Millisecond, Equals, true)
+	c.Assert(m["routeUpdate"], Equals, time.Duration(0))
+
+	stats.record(&lastReplaceStats, Route{}, "replace")
+	c.Assert(GetReplaceStatistics()["linkLookup"], Equals, m["linkLookup"])
+}
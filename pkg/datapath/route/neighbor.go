@@ -0,0 +1,132 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Neighbor is a permanent neighbor (ARP/NDP) entry which maps the IP address
+// of a nexthop to its MAC address
+type Neighbor struct {
+	// IP is the IP address of the neighbor
+	IP net.IP
+	// MAC is the MAC address of the neighbor
+	MAC net.HardwareAddr
+	// Device is the device through which the neighbor is reachable
+	Device string
+}
+
+func (n *Neighbor) getLogger() *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"ip":                n.IP,
+		"mac":               n.MAC,
+		logfields.Interface: n.Device,
+	})
+}
+
+// getNetlinkNeigh returns the neighbor configuration as netlink.Neigh
+// pointing to link
+func (n *Neighbor) getNetlinkNeigh(link netlink.Link) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       ipFamily(n.IP),
+		State:        netlink.NUD_PERMANENT,
+		IP:           n.IP,
+		HardwareAddr: n.MAC,
+	}
+}
+
+// lookupNeighbor finds a permanent neighbor entry with the same IP and MAC
+// address as the specified neighbor entry
+func lookupNeighbor(h *netlink.Handle, neigh *netlink.Neigh) *netlink.Neigh {
+	neighs, err := h.NeighList(neigh.LinkIndex, neigh.Family)
+	if err != nil {
+		return nil
+	}
+
+	for _, n := range neighs {
+		if n.IP.Equal(neigh.IP) && bytes.Equal(n.HardwareAddr, neigh.HardwareAddr) &&
+			n.State&netlink.NUD_PERMANENT != 0 {
+			return &n
+		}
+	}
+
+	return nil
+}
+
+// replaceNeighbor installs the neighbor entry pointing to link unless an equal
+// entry is already installed
+func replaceNeighbor(h *netlink.Handle, link netlink.Link, neighbor Neighbor) (bool, error) {
+	neighSpec := neighbor.getNetlinkNeigh(link)
+	if lookupNeighbor(h, neighSpec) == nil {
+		if err := h.NeighSet(neighSpec); err != nil {
+			return false, fmt.Errorf("unable to add neighbor: %s", err)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// ReplaceNeighbor adds or replaces the specified permanent neighbor entry if
+// necessary
+func ReplaceNeighbor(neighbor Neighbor) error {
+	link, err := defaultHandle.LinkByName(neighbor.Device)
+	if err != nil {
+		return fmt.Errorf("unable to lookup interface %s: %s", neighbor.Device, err)
+	}
+
+	added, err := replaceNeighbor(defaultHandle, link, neighbor)
+	if err != nil {
+		neighbor.getLogger().WithError(err).Error("Unable to add neighbor")
+		return err
+	} else if added {
+		neighbor.getLogger().Info("Added neighbor")
+	}
+
+	return nil
+}
+
+// neighDel removes the neighbor entry via the netlink handle. It is replaced in
+// unit tests to fake netlink errors.
+var neighDel = func(h *netlink.Handle, neigh *netlink.Neigh) error {
+	return h.NeighDel(neigh)
+}
+
+// DeleteNeighbor removes a neighbor entry
+func DeleteNeighbor(neighbor Neighbor) error {
+	link, err := defaultHandle.LinkByName(neighbor.Device)
+	if err != nil {
+		return fmt.Errorf("unable to lookup interface %s: %s", neighbor.Device, err)
+	}
+
+	if err := neighDel(defaultHandle, neighbor.getNetlinkNeigh(link)); err != nil {
+		neighbor.getLogger().WithError(err).Error("Unable to delete neighbor")
+		return err
+	}
+
+	neighbor.getLogger().Info("Deleted neighbor")
+
+	return nil
+}
@@ -15,14 +15,22 @@
 package route
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/cilium/cilium/pkg/backoff"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/mtu"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 type Route struct {
@@ -31,7 +39,131 @@ type Route struct {
 	Local   net.IP
 	Device  string
 	MTU     int
-	Scope   netlink.Scope
+	// MTUExplicit installs the route with MTU as is. By default, a
+	// non-zero MTU is replaced with the device or route MTU of the mtu
+	// package, which does not fit interfaces with a different MTU.
+	MTUExplicit bool
+	Scope       netlink.Scope
+	// Table is the routing table the route is installed into. If 0, the
+	// main routing table is used.
+	Table int
+	// Priority is the metric of the route. If 0, the kernel default
+	// metric is used.
+	Priority int
+	// Proto is the routing protocol identifier of the route. It allows to
+	// identify routes installed by Cilium. If 0, the kernel default
+	// protocol is used.
+	Proto int
+	// Type is the route type, e.g. unix.RTN_BLACKHOLE. If 0, a unicast
+	// route is installed. Blackhole, unreachable and prohibit routes do
+	// not require Device or Nexthop to be set.
+	Type int
+	// Nexthops is the list of nexthops of a multipath route. If set,
+	// Nexthop is ignored.
+	Nexthops []NexthopInfo
+	// Onlink marks the nexthop as directly reachable through the device
+	// even if it is not covered by any prefix of the device. No L2
+	// nexthop route is installed for onlink routes.
+	Onlink bool
+	// Tos is the type of service the route matches on. It is ignored for
+	// IPv6 routes as IPv6 routes cannot match on the traffic class.
+	Tos int
+	// AdvMSS is the maximal segment size advertised to peers when
+	// establishing TCP connections via the route. If 0, the kernel derives
+	// it from the MTU.
+	AdvMSS int
+	// AdvMSSOverhead derives AdvMSS from the MTU of the route if AdvMSS
+	// is 0. The advertised segment size is the MTU minus the overhead,
+	// e.g. TCPOverheadIPv4 plus the encapsulation overhead of the tunnel
+	// the route points to, see mtu.TunnelOverhead. If 0, AdvMSS is not
+	// derived.
+	AdvMSSOverhead int
+	// LinkIndex is the interface index of the device the route points to.
+	// If set, it takes precedence over Device and saves the lookup of the
+	// device by name.
+	LinkIndex int
+	// FromPrefix restricts the route to packets with a source address
+	// within the prefix. Plain routes cannot match on the source, such
+	// routes must be installed into a separate table which is selected by
+	// a Rule with From set to the prefix. ReplaceRoute rejects routes
+	// with FromPrefix set, it is only rendered by ToIPCommand.
+	FromPrefix *net.IPNet
+	// Encap is the lightweight tunnel encapsulation applied to packets
+	// forwarded via the route, e.g. *netlink.MPLSEncap. The nexthop of
+	// an encapsulated route is virtual, hence no L2 nexthop route is
+	// installed for it.
+	Encap netlink.Encap
+	// NexthopMAC is the MAC address of Nexthop. If set, a permanent
+	// neighbor entry is installed for the nexthop so it never has to be
+	// resolved.
+	NexthopMAC net.HardwareAddr
+	// NexthopScope is the scope of the L2 nexthop route which makes
+	// Nexthop reachable through the device. If 0, netlink.SCOPE_LINK is
+	// used.
+	NexthopScope netlink.Scope
+	// StrictSrc includes the preferred source address Local when matching
+	// the route against installed routes. A change of Local then causes
+	// the installed route to be replaced. By default, Local is ignored.
+	StrictSrc bool
+}
+
+const (
+	// TCPOverheadIPv4 is the size of the IPv4 and TCP headers without
+	// options
+	TCPOverheadIPv4 = 40
+
+	// TCPOverheadIPv6 is the size of the IPv6 and TCP headers without
+	// options
+	TCPOverheadIPv6 = 60
+)
+
+// NexthopInfo is a single nexthop of a multipath route
+type NexthopInfo struct {
+	// IP is the IP address of the nexthop
+	IP net.IP
+	// Device is the device through which the nexthop is reachable. If
+	// empty, the device of the route is used.
+	Device string
+	// Weight is the relative weight of the nexthop, e.g. weights 3 and 1
+	// split the traffic 3:1 across two nexthops. If 0, a weight of 1 is
+	// used. The kernel stores the weight minus one in the hops field of
+	// the nexthop, the conversion is done transparently.
+	Weight int
+}
+
+// isSpecialType returns true if the route is of a type which drops packets
+// instead of forwarding them and thus requires neither a device nor a
+// nexthop. These are blackhole, unreachable and prohibit routes.
+func (r *Route) isSpecialType() bool {
+	switch r.Type {
+	case unix.RTN_BLACKHOLE, unix.RTN_UNREACHABLE, unix.RTN_PROHIBIT:
+		return true
+	}
+
+	return false
+}
+
+// isAddressType returns true if the route is a local or broadcast route. Such
+// routes describe addresses of the device the route points to and thus never
+// have a nexthop.
+func (r *Route) isAddressType() bool {
+	return r.Type == unix.RTN_LOCAL || r.Type == unix.RTN_BROADCAST
+}
+
+// requiresNexthopRoute returns true if an L2 nexthop route is required to make
+// the nexthop of the route reachable through the device. Onlink routes do not
+// require the nexthop to be reachable, the nexthop of encapsulated routes is
+// virtual and directly attached routes have no nexthop at all.
+func (r *Route) requiresNexthopRoute() bool {
+	return !r.Onlink && r.Encap == nil && r.Nexthop != nil && !r.isAddressType()
+}
+
+// isGatewayOnly returns true if the route specifies a single nexthop but no
+// device. The kernel resolves the device through which the nexthop is
+// reachable when the route is installed.
+func (r *Route) isGatewayOnly() bool {
+	return r.Device == "" && r.LinkIndex == 0 && r.Nexthop != nil &&
+		len(r.Nexthops) == 0 && !r.isSpecialType()
 }
 
 func (r *Route) getLogger() *logrus.Entry {
@@ -39,40 +171,272 @@ func (r *Route) getLogger() *logrus.Entry {
 		"prefix":            r.Prefix,
 		"nexthop":           r.Nexthop,
 		"local":             r.Local,
+		"table":             r.Table,
+		"priority":          r.Priority,
 		logfields.Interface: r.Device,
+		logfields.Route:     r.String(),
 	})
 }
 
+// getLink returns the link the route points to. The link is looked up by
+// LinkIndex if set and by the name in Device otherwise.
+func (r *Route) getLink(h *netlink.Handle) (netlink.Link, error) {
+	if r.LinkIndex != 0 {
+		link, err := h.LinkByIndex(r.LinkIndex)
+		if err != nil {
+			return nil, fmt.Errorf("unable to lookup interface with index %d: %s", r.LinkIndex, err)
+		}
+
+		return link, nil
+	}
+
+	link, err := h.LinkByName(r.Device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup interface %s: %s", r.Device, err)
+	}
+
+	return link, nil
+}
+
+// getScope returns the scope of the route. Local and broadcast routes default
+// to the scope required by the kernel.
+func (r *Route) getScope() netlink.Scope {
+	if scope, ok := addressTypeScopes[r.Type]; ok && r.Scope == 0 {
+		return scope
+	}
+
+	return r.Scope
+}
+
+// getAdvMSS returns the advertised segment size of the route if it was
+// installed with the specified MTU
+func (r *Route) getAdvMSS(mtu int) int {
+	if r.AdvMSS != 0 || r.AdvMSSOverhead == 0 || mtu <= r.AdvMSSOverhead {
+		return r.AdvMSS
+	}
+
+	return mtu - r.AdvMSSOverhead
+}
+
 // getNetlinkRoute returns the route configuration as netlink.Route
 func (r *Route) getNetlinkRoute() netlink.Route {
 	rt := netlink.Route{
-		Dst: &r.Prefix,
-		Src: r.Local,
-		MTU: r.MTU,
+		Dst:      &r.Prefix,
+		Src:      r.Local,
+		MTU:      r.MTU,
+		Table:    r.Table,
+		Priority: r.Priority,
+		Protocol: r.Proto,
+		Type:     r.Type,
+		AdvMSS:   r.getAdvMSS(r.MTU),
+		Encap:    r.Encap,
 	}
 
-	if r.Nexthop != nil {
+	if len(r.Nexthops) > 0 {
+		rt.MultiPath = make([]*netlink.NexthopInfo, 0, len(r.Nexthops))
+		for _, nh := range r.Nexthops {
+			hop := &netlink.NexthopInfo{Gw: nh.IP}
+			if nh.Weight > 0 {
+				hop.Hops = nh.Weight - 1
+			}
+			rt.MultiPath = append(rt.MultiPath, hop)
+		}
+	} else if r.Nexthop != nil {
 		rt.Gw = *r.Nexthop
 	}
 
-	if r.Scope != 0 {
-		rt.Scope = r.Scope
+	rt.Scope = r.getScope()
+
+	if r.Onlink {
+		rt.SetFlag(netlink.FLAG_ONLINK)
+	}
+
+	if r.Prefix.IP.To4() != nil {
+		rt.Tos = r.Tos
 	}
 
 	return rt
 }
 
+// newRouteFromNetlink converts a netlink.Route which points to the specified
+// device into a Route
+func newRouteFromNetlink(device string, nlRoute *netlink.Route) Route {
+	r := Route{
+		Local:    nlRoute.Src,
+		Device:   device,
+		MTU:      nlRoute.MTU,
+		Scope:    nlRoute.Scope,
+		Table:    nlRoute.Table,
+		Priority: nlRoute.Priority,
+		Proto:    nlRoute.Protocol,
+		Onlink:   nlRoute.Flags&int(netlink.FLAG_ONLINK) != 0,
+		Tos:      nlRoute.Tos,
+		AdvMSS:   nlRoute.AdvMSS,
+		Encap:    nlRoute.Encap,
+	}
+
+	if nlRoute.Type != unix.RTN_UNICAST {
+		r.Type = nlRoute.Type
+	}
+
+	for _, hop := range nlRoute.MultiPath {
+		r.Nexthops = append(r.Nexthops, NexthopInfo{
+			IP:     hop.Gw,
+			Weight: hop.Hops + 1,
+		})
+	}
+
+	if nlRoute.Dst != nil {
+		r.Prefix = *nlRoute.Dst
+	} else if nlRoute.Gw.To4() != nil || nlRoute.Src.To4() != nil {
+		// A default route does not carry a destination
+		r.Prefix = net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+	} else {
+		r.Prefix = net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+	}
+
+	if nlRoute.Gw != nil {
+		nexthop := nlRoute.Gw
+		r.Nexthop = &nexthop
+	}
+
+	return r
+}
+
+// ErrLinkNotFound is returned by FromNetlinkRoute if the link referenced by
+// the route no longer exists
+var ErrLinkNotFound = errors.New("link not found")
+
+// linkName returns the name of the link with the specified index. If the
+// link does not exist, a synthetic name derived from the index and
+// ErrLinkNotFound are returned.
+func linkName(index int) (string, error) {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return fmt.Sprintf("if%d", index), ErrLinkNotFound
+	}
+
+	return link.Attrs().Name, nil
+}
+
+// FromNetlinkRoute converts a netlink.Route as read from the kernel into a
+// Route. The device of the route and of all nexthops is resolved by the
+// interface index. If a link no longer exists, the device is set to a
+// synthetic name of the form "if<index>" and ErrLinkNotFound is returned
+// along with the otherwise complete route.
+func FromNetlinkRoute(nr netlink.Route) (Route, error) {
+	var (
+		device string
+		err    error
+	)
+
+	if nr.LinkIndex != 0 {
+		device, err = linkName(nr.LinkIndex)
+	}
+
+	r := newRouteFromNetlink(device, &nr)
+	r.LinkIndex = nr.LinkIndex
+
+	for i, hop := range nr.MultiPath {
+		if hop.LinkIndex == 0 || hop.LinkIndex == nr.LinkIndex {
+			continue
+		}
+
+		hopDevice, hopErr := linkName(hop.LinkIndex)
+		if hopErr != nil {
+			err = hopErr
+		}
+		r.Nexthops[i].Device = hopDevice
+	}
+
+	return r, err
+}
+
 // getNexthopAsIPNet returns the nexthop of the route as IPNet
 func (r *Route) getNexthopAsIPNet() *net.IPNet {
 	if r.Nexthop == nil {
 		return nil
 	}
 
-	if r.Nexthop.To4() != nil {
-		return &net.IPNet{IP: *r.Nexthop, Mask: net.CIDRMask(32, 32)}
+	return ipAsIPNet(*r.Nexthop)
+}
+
+// ipAsIPNet returns the IP as IPNet with a full mask
+func ipAsIPNet(ip net.IP) *net.IPNet {
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// DefaultRoute returns a default route via the specified nexthop and device.
+// The prefix 0.0.0.0/0 or ::/0 is chosen according to the address family of
+// the nexthop.
+func DefaultRoute(nexthop net.IP, device string) Route {
+	r := Route{
+		Prefix:  net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		Nexthop: &nexthop,
+		Device:  device,
+	}
+
+	if nexthop.To4() != nil {
+		r.Prefix = net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+	}
+
+	return r
+}
+
+// specialTypeNames maps the special route types to their name as used by the
+// ip command
+var specialTypeNames = map[int]string{
+	unix.RTN_BLACKHOLE:   "blackhole",
+	unix.RTN_UNREACHABLE: "unreachable",
+	unix.RTN_PROHIBIT:    "prohibit",
+}
+
+// addressTypeNames maps the local and broadcast route types to their name as
+// used by the ip command
+var addressTypeNames = map[int]string{
+	unix.RTN_LOCAL:     "local",
+	unix.RTN_BROADCAST: "broadcast",
+}
+
+// addressTypeScopes maps the local and broadcast route types to the scope
+// chosen by the ip command if none is specified. The kernel rejects these
+// routes with a wider scope.
+var addressTypeScopes = map[int]netlink.Scope{
+	unix.RTN_LOCAL:     netlink.SCOPE_HOST,
+	unix.RTN_BROADCAST: netlink.SCOPE_LINK,
+}
+
+// typeName returns the name of the route type as used by the ip command. The
+// unicast type is implicit and has no name.
+func typeName(routeType int) (string, bool) {
+	if name, ok := specialTypeNames[routeType]; ok {
+		return name, true
+	}
+
+	name, ok := addressTypeNames[routeType]
+	return name, ok
+}
+
+// scopeNames maps route scopes to their name as used by the ip command
+var scopeNames = map[netlink.Scope]string{
+	netlink.SCOPE_UNIVERSE: "global",
+	netlink.SCOPE_SITE:     "site",
+	netlink.SCOPE_LINK:     "link",
+	netlink.SCOPE_HOST:     "host",
+	netlink.SCOPE_NOWHERE:  "nowhere",
+}
+
+// scopeName returns the name of the scope as used by the ip command
+func scopeName(scope netlink.Scope) string {
+	if name, ok := scopeNames[scope]; ok {
+		return name
 	}
 
-	return &net.IPNet{IP: *r.Nexthop, Mask: net.CIDRMask(128, 128)}
+	return fmt.Sprintf("%d", scope)
 }
 
 // ToIPCommand converts the route into a full "ip route ..." command
@@ -81,205 +445,1988 @@ func (r *Route) ToIPCommand(dev string) []string {
 	if r.Prefix.IP.To4() == nil {
 		res = append(res, "-6")
 	}
-	res = append(res, "route", "add", r.Prefix.String())
+	res = append(res, "route", "add")
+	if name, ok := typeName(r.Type); ok {
+		res = append(res, name)
+	}
+	res = append(res, r.Prefix.String())
+	if r.FromPrefix != nil {
+		res = append(res, "from", r.FromPrefix.String())
+	}
+	if encap, ok := r.Encap.(*netlink.MPLSEncap); ok {
+		res = append(res, "encap", "mpls", encap.String())
+	}
 	if r.Nexthop != nil {
 		res = append(res, "via", r.Nexthop.String())
 	}
+	if r.Onlink {
+		res = append(res, "onlink")
+	}
 	if r.MTU != 0 {
 		res = append(res, "mtu", fmt.Sprintf("%d", r.MTU))
 	}
-	res = append(res, "dev", dev)
+	if advMSS := r.getAdvMSS(r.MTU); advMSS != 0 {
+		res = append(res, "advmss", fmt.Sprintf("%d", advMSS))
+	}
+	if r.Priority != 0 {
+		res = append(res, "metric", fmt.Sprintf("%d", r.Priority))
+	}
+	if r.Table != 0 {
+		res = append(res, "table", fmt.Sprintf("%d", r.Table))
+	}
+	if r.Scope != 0 {
+		res = append(res, "scope", scopeName(r.Scope))
+	}
+	if r.Tos != 0 && r.Prefix.IP.To4() != nil {
+		res = append(res, "tos", fmt.Sprintf("%d", r.Tos))
+	}
+	if len(r.Nexthops) > 0 {
+		for _, nh := range r.Nexthops {
+			nhDev := nh.Device
+			if nhDev == "" {
+				nhDev = dev
+			}
+			res = append(res, "nexthop", "via", nh.IP.String(), "dev", nhDev)
+			if nh.Weight > 0 {
+				res = append(res, "weight", fmt.Sprintf("%d", nh.Weight))
+			}
+		}
+	} else if !r.isSpecialType() && dev != "" {
+		res = append(res, "dev", dev)
+	}
 	return res
 }
 
-// ByMask is used to sort an array of routes by mask, narrow first.
-type ByMask []Route
-
-func (a ByMask) Len() int {
-	return len(a)
+// ToIPDeleteCommand converts the route into a full "ip route del ..." command
+// which removes the route. Only the fields considered by DeleteRoute are
+// included.
+func (r *Route) ToIPDeleteCommand(dev string) []string {
+	res := []string{"ip"}
+	if r.Prefix.IP.To4() == nil {
+		res = append(res, "-6")
+	}
+	res = append(res, "route", "del")
+	if name, ok := typeName(r.Type); ok {
+		res = append(res, name)
+	}
+	res = append(res, r.Prefix.String())
+	if r.Table != 0 {
+		res = append(res, "table", fmt.Sprintf("%d", r.Table))
+	}
+	if r.Priority != 0 {
+		res = append(res, "metric", fmt.Sprintf("%d", r.Priority))
+	}
+	// Scope and TOS can only be specified for IPv4
+	if r.Prefix.IP.To4() != nil {
+		if r.Scope != 0 {
+			res = append(res, "scope", scopeName(r.Scope))
+		}
+		if r.Tos != 0 {
+			res = append(res, "tos", fmt.Sprintf("%d", r.Tos))
+		}
+	}
+	// Special and multipath routes do not point to a single device
+	if !r.isSpecialType() && len(r.Nexthops) == 0 {
+		res = append(res, "dev", dev)
+	}
+	return res
 }
 
-func (a ByMask) Less(i, j int) bool {
-	lenA, _ := a[i].Prefix.Mask.Size()
-	lenB, _ := a[j].Prefix.Mask.Size()
-	return lenA > lenB
+// String returns a human readable representation of the route in the style of
+// "ip route", e.g. "10.0.0.0/24 via 10.0.0.1 dev cilium_host mtu 1450". Fields
+// which are not set are omitted.
+func (r Route) String() string {
+	var res []string
+	if name, ok := typeName(r.Type); ok {
+		res = append(res, name)
+	}
+	res = append(res, r.Prefix.String())
+	if r.FromPrefix != nil {
+		res = append(res, "from", r.FromPrefix.String())
+	}
+	if r.Nexthop != nil {
+		res = append(res, "via", r.Nexthop.String())
+	}
+	if r.Device != "" {
+		res = append(res, "dev", r.Device)
+	} else if r.LinkIndex != 0 {
+		res = append(res, "dev", fmt.Sprintf("#%d", r.LinkIndex))
+	}
+	for _, nh := range r.Nexthops {
+		res = append(res, "nexthop", "via", nh.IP.String())
+		if nh.Device != "" {
+			res = append(res, "dev", nh.Device)
+		}
+		if nh.Weight > 0 {
+			res = append(res, "weight", fmt.Sprintf("%d", nh.Weight))
+		}
+	}
+	if r.Local != nil {
+		res = append(res, "src", r.Local.String())
+	}
+	if r.Onlink {
+		res = append(res, "onlink")
+	}
+	if r.MTU != 0 {
+		res = append(res, "mtu", fmt.Sprintf("%d", r.MTU))
+	}
+	if advMSS := r.getAdvMSS(r.MTU); advMSS != 0 {
+		res = append(res, "advmss", fmt.Sprintf("%d", advMSS))
+	}
+	if r.Priority != 0 {
+		res = append(res, "metric", fmt.Sprintf("%d", r.Priority))
+	}
+	if r.Scope != 0 {
+		res = append(res, "scope", scopeName(r.Scope))
+	}
+	if r.Tos != 0 {
+		res = append(res, "tos", fmt.Sprintf("%d", r.Tos))
+	}
+	if r.Table != 0 {
+		res = append(res, "table", fmt.Sprintf("%d", r.Table))
+	}
+	if r.Proto != 0 {
+		res = append(res, "proto", fmt.Sprintf("%d", r.Proto))
+	}
+	return strings.Join(res, " ")
 }
 
-func (a ByMask) Swap(i, j int) {
-	a[i], a[j] = a[j], a[i]
+// ListRoutes returns all routes of the specified address family of all routing
+// tables which point to the specified device. The address family can be
+// netlink.FAMILY_V4, netlink.FAMILY_V6 or netlink.FAMILY_ALL.
+func ListRoutes(device string, family int) ([]Route, error) {
+	return listRoutes(defaultHandle, device, family)
 }
 
-func ipFamily(ip net.IP) int {
-	if ip.To4() == nil {
-		return netlink.FAMILY_V6
+func listRoutes(h *netlink.Handle, device string, family int) ([]Route, error) {
+	link, err := h.LinkByName(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup interface %s: %s", device, err)
 	}
 
-	return netlink.FAMILY_V4
-}
+	// RouteList() only returns routes of the main routing table,
+	// RT_TABLE_UNSPEC matches all tables
+	filter := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Table:     unix.RT_TABLE_UNSPEC,
+	}
 
-// lookup finds a particular route as specified by the filter which points
-// to the specified device. The filter route can have the following fields set:
-//  - Dst
-//  - LinkIndex
-//  - Scope
-//  - Gw
-func lookup(link netlink.Link, route *netlink.Route) *netlink.Route {
-	routes, err := netlink.RouteList(link, ipFamily(route.Dst.IP))
+	nlRoutes, err := h.RouteListFiltered(family, filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("unable to list routes of interface %s: %s", device, err)
 	}
 
-	for _, r := range routes {
-		if r.Dst != nil && route.Dst == nil {
-			continue
-		}
+	routes := make([]Route, 0, len(nlRoutes))
+	for i := range nlRoutes {
+		routes = append(routes, newRouteFromNetlink(device, &nlRoutes[i]))
+	}
 
-		if route.Dst != nil && r.Dst == nil {
-			continue
-		}
+	return routes, nil
+}
 
-		aMaskLen, aMaskBits := r.Dst.Mask.Size()
-		bMaskLen, bMaskBits := route.Dst.Mask.Size()
-		if r.LinkIndex == route.LinkIndex && r.Scope == route.Scope &&
-			aMaskLen == bMaskLen && aMaskBits == bMaskBits &&
-			r.Dst.IP.Equal(route.Dst.IP) && r.Gw.Equal(route.Gw) {
-			return &r
+// ListRoutesByProto returns all routes of all devices and routing tables which
+// have been installed with the specified routing protocol identifier. Routes
+// pointing to a device which no longer exists are reported with a synthetic
+// device name, see FromNetlinkRoute.
+func ListRoutesByProto(proto int) ([]Route, error) {
+	filter := &netlink.Route{
+		Protocol: proto,
+		Table:    unix.RT_TABLE_UNSPEC,
+	}
+
+	nlRoutes, err := defaultHandle.RouteListFiltered(netlink.FAMILY_ALL, filter,
+		netlink.RT_FILTER_PROTOCOL|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list routes with protocol %d: %s", proto, err)
+	}
+
+	routes := make([]Route, 0, len(nlRoutes))
+	for i := range nlRoutes {
+		route, err := FromNetlinkRoute(nlRoutes[i])
+		if err != nil && err != ErrLinkNotFound {
+			return nil, err
 		}
+
+		routes = append(routes, route)
 	}
 
-	return nil
+	return routes, nil
 }
 
-func createNexthopRoute(link netlink.Link, routerNet *net.IPNet) *netlink.Route {
-	// This is the L2 route which makes router IP available behind the
-	// interface.
-	rt := &netlink.Route{
-		LinkIndex: link.Attrs().Index,
-		Dst:       routerNet,
+// CleanupRoutes removes all routes of all devices, routing tables and address
+// families which have been installed with the specified routing protocol
+// identifier and returns the number of routes removed. Routes installed with
+// any other protocol are never removed, proto must thus not be 0. Routes
+// which no longer exist are ignored. Deletion continues past individual
+// failures and all errors are combined into the returned error.
+func CleanupRoutes(proto int) (int, error) {
+	if proto == 0 {
+		return 0, fmt.Errorf("routing protocol identifier must be specified")
 	}
 
-	// Known issue: scope for IPv6 routes is not propagated correctly. If
-	// we set the scope here, lookup() will be unable to identify the route
-	// again and we will continously re-add the route
-	if routerNet.IP.To4() != nil {
-		rt.Scope = netlink.SCOPE_LINK
+	deleted, _, err := deleteRoutes(defaultHandle, routeFilter{
+		description: fmt.Sprintf("with protocol %d", proto),
+		family:      netlink.FAMILY_ALL,
+		route: netlink.Route{
+			Protocol: proto,
+			Table:    unix.RT_TABLE_UNSPEC,
+		},
+		mask: netlink.RT_FILTER_PROTOCOL | netlink.RT_FILTER_TABLE,
+		// Never rely on the filtering alone to remove foreign routes
+		keep: func(r *netlink.Route) bool {
+			return r.Protocol != proto
+		},
+	}, combineErrors)
+	if deleted < 0 {
+		return 0, err
 	}
 
-	return rt
+	log.WithFields(logrus.Fields{
+		"proto":   proto,
+		"deleted": deleted,
+	}).Info("Cleaned up routes")
+
+	return deleted, err
 }
 
-// replaceNexthopRoute verifies that the L2 route for the router IP which is
-// used as nexthop for all node routes is properly installed. If unavailable or
-// incorrect, it will be replaced with the proper L2 route.
-func replaceNexthopRoute(link netlink.Link, routerNet *net.IPNet) (bool, error) {
-	route := createNexthopRoute(link, routerNet)
-	if lookup(link, route) == nil {
-		scopedLog := log.WithField(logfields.Route, route)
+// ListAllRoutes returns all routes of the specified address family of all
+// devices and routing tables. Routes pointing to a device which no longer
+// exists are reported with a synthetic device name, see FromNetlinkRoute.
+func ListAllRoutes(family int) ([]Route, error) {
+	nlRoutes, err := listAllNetlinkRoutes(defaultHandle, family)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := netlink.RouteReplace(route); err != nil {
-			scopedLog.WithError(err).Error("Unable to add L2 nexthop route")
-			return false, fmt.Errorf("unable to add L2 nexthop route: %s", err)
+	routes := make([]Route, 0, len(nlRoutes))
+	for i := range nlRoutes {
+		route, err := FromNetlinkRoute(nlRoutes[i])
+		if err != nil && err != ErrLinkNotFound {
+			return nil, err
 		}
 
-		scopedLog.Info("Added L2 nexthop route")
-		return true, nil
+		routes = append(routes, route)
 	}
 
-	return false, nil
+	return routes, nil
 }
 
-// deleteNexthopRoute deletes
-func deleteNexthopRoute(link netlink.Link, routerNet *net.IPNet) error {
-	route := createNexthopRoute(link, routerNet)
-	if err := netlink.RouteDel(route); err != nil {
-		return fmt.Errorf("unable to delete L2 nexthop route: %s", err)
+// prefixesOverlap returns true if one of the prefixes contains the other
+func prefixesOverlap(a, b *net.IPNet) bool {
+	if ipFamily(a.IP) != ipFamily(b.IP) {
+		return false
 	}
 
-	return nil
+	return a.Contains(b.IP) || b.Contains(a.IP)
 }
 
-func replaceRoute(route Route) (bool, error) {
-	link, err := netlink.LinkByName(route.Device)
-	if err != nil {
-		return false, fmt.Errorf("unable to lookup interface %s: %s", route.Device, err)
+// FindOverlapping returns all routes installed in the routing table of the
+// specified route whose prefix overlaps with the prefix of the route, i.e.
+// which is equal to, a subnet of or a superset of the prefix. Only routes
+// pointing to the device of the route are considered unless the route does
+// not point to a single device. The result is advisory, the route is not
+// installed.
+func FindOverlapping(route Route) ([]Route, error) {
+	filter := &netlink.Route{
+		Table: getTable(route.Table),
 	}
+	filterMask := netlink.RT_FILTER_TABLE
 
-	routerNet := route.getNexthopAsIPNet()
-	if _, err := replaceNexthopRoute(link, routerNet); err != nil {
-		return false, fmt.Errorf("unable to add nexthop route: %s", err)
-	}
+	if !route.isSpecialType() && len(route.Nexthops) == 0 {
+		link, err := route.getLink(defaultHandle)
+		if err != nil {
+			return nil, err
+		}
 
-	routeSpec := route.getNetlinkRoute()
-	routeSpec.LinkIndex = link.Attrs().Index
+		filter.LinkIndex = link.Attrs().Index
+		filterMask |= netlink.RT_FILTER_OIF
+	}
 
-	if routeSpec.MTU != 0 {
-		// If the route includes the local address, then the route is for
-		// local containers and we can use a high MTU for transmit. Otherwise,
-		// it needs to be able to fit within the MTU of tunnel devices.
-		if route.Prefix.Contains(route.Local) {
-			routeSpec.MTU = mtu.GetDeviceMTU()
-		} else {
-			routeSpec.MTU = mtu.GetRouteMTU()
-		}
+	nlRoutes, err := defaultHandle.RouteListFiltered(ipFamily(route.Prefix.IP), filter, filterMask)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list routes of table %d: %s", filter.Table, err)
 	}
 
-	if lookup(link, &routeSpec) == nil {
-		if err := netlink.RouteReplace(&routeSpec); err != nil {
-			return false, err
+	routes := []Route{}
+	for i := range nlRoutes {
+		r, err := FromNetlinkRoute(nlRoutes[i])
+		if err != nil && err != ErrLinkNotFound {
+			return nil, err
 		}
 
-		return true, nil
+		if prefixesOverlap(&r.Prefix, &route.Prefix) {
+			routes = append(routes, r)
+		}
 	}
 
-	return false, nil
+	return routes, nil
 }
 
-// ReplaceRoute adds or replaces the specified route if necessary
-func ReplaceRoute(route Route) error {
-	replaced, err := replaceRoute(route)
+// routeFilter selects the routes removed by deleteRoutes
+type routeFilter struct {
+	// description describes the selected routes in error messages, e.g.
+	// "of interface eth0"
+	description string
+	// family is the address family of the routes
+	family int
+	// route and mask are passed to netlink.RouteListFiltered()
+	route netlink.Route
+	mask  uint64
+	// keep returns true for listed routes which must not be removed
+	keep func(*netlink.Route) bool
+}
+
+// errorPolicy decides which errors deleteRoutes returns if routes cannot be
+// removed
+type errorPolicy int
+
+const (
+	// combineErrors combines the errors of all failed deletions
+	combineErrors errorPolicy = iota
+	// firstError returns the error of the first failed deletion
+	firstError
+)
+
+// isLocalTableRoute returns true if the route belongs to the local routing
+// table which is maintained by the kernel
+func isLocalTableRoute(r *netlink.Route) bool {
+	return r.Table == unix.RT_TABLE_LOCAL
+}
+
+// deleteRoutes removes all routes selected by the filter. Routes which no
+// longer exist are ignored. Deletion continues past individual failures, the
+// errors are returned according to policy along with the number of routes
+// removed and the number of routes which could not be removed. If the routes
+// cannot be listed, deleted is -1.
+func deleteRoutes(h *netlink.Handle, filter routeFilter, policy errorPolicy) (deleted, failed int, err error) {
+	nlRoutes, err := h.RouteListFiltered(filter.family, &filter.route, filter.mask)
 	if err != nil {
-		route.getLogger().WithError(err).Error("Unable to add route")
-		return err
-	} else if replaced {
-		route.getLogger().Info("Updated route")
+		return -1, 0, fmt.Errorf("unable to list routes %s: %s", filter.description, err)
 	}
 
-	return nil
+	errs := []string{}
+	for i := range nlRoutes {
+		if filter.keep != nil && filter.keep(&nlRoutes[i]) {
+			continue
+		}
+
+		route := newRouteFromNetlink("", &nlRoutes[i])
+		if h == defaultHandle {
+			installedRoutes.invalidate(route.Prefix, route.Table)
+		}
+
+		if err := routeDel(h, &nlRoutes[i]); err != nil {
+			if !isNotFound(err) {
+				failed++
+				errs = append(errs, fmt.Sprintf("unable to delete route %s: %s", nlRoutes[i].Dst, err))
+			}
+			continue
+		}
+
+		deleted++
+	}
+
+	switch {
+	case len(errs) == 0:
+		return deleted, failed, nil
+	case policy == firstError:
+		return deleted, failed, errors.New(errs[0])
+	default:
+		return deleted, failed, errors.New(strings.Join(errs, "; "))
+	}
 }
 
-func deleteRoute(route Route) error {
-	link, err := netlink.LinkByName(route.Device)
+// DeleteRoutesForDevice removes all routes of all routing tables which point
+// to the specified device. If proto is not 0, only routes installed with the
+// specified routing protocol identifier are removed. Routes of the local
+// routing table are maintained by the kernel and are never removed. Deletion
+// continues past individual failures and all errors are combined into the
+// returned error.
+func DeleteRoutesForDevice(device string, proto int) error {
+	link, err := defaultHandle.LinkByName(device)
 	if err != nil {
-		return fmt.Errorf("unable to lookup interface %s: %s", route.Device, err)
+		return fmt.Errorf("unable to lookup interface %s: %s", device, err)
 	}
 
-	// Deletion of routes with Nexthop or Local set fails for IPv6.
-	// Therefore do not use getNetlinkRoute().
-	routeSpec := netlink.Route{
-		Dst:       &route.Prefix,
-		LinkIndex: link.Attrs().Index,
+	filter := routeFilter{
+		description: "of interface " + device,
+		family:      netlink.FAMILY_ALL,
+		route: netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Protocol:  proto,
+			Table:     unix.RT_TABLE_UNSPEC,
+		},
+		mask: netlink.RT_FILTER_OIF | netlink.RT_FILTER_TABLE,
+		keep: isLocalTableRoute,
 	}
-
-	// Scope can only be specified for IPv4
-	if route.Prefix.IP.To4() != nil {
-		routeSpec.Scope = route.Scope
+	if proto != 0 {
+		filter.mask |= netlink.RT_FILTER_PROTOCOL
 	}
 
-	if err := netlink.RouteDel(&routeSpec); err != nil {
+	if _, _, err := deleteRoutes(defaultHandle, filter, combineErrors); err != nil {
 		return err
 	}
 
+	log.WithFields(logrus.Fields{
+		logfields.Interface: device,
+		"proto":             proto,
+	}).Info("Deleted routes of device")
+
 	return nil
 }
 
-// DeleteRoute removes a route
-func DeleteRoute(route Route) error {
-	if err := deleteRoute(route); err != nil {
-		route.getLogger().WithError(err).Error("Unable to delete route")
+// FlushRoutes removes all routes of the specified address family of all
+// routing tables which point to the specified device, regardless of the
+// routing protocol identifier they have been installed with. The address
+// family can be netlink.FAMILY_V4, netlink.FAMILY_V6 or netlink.FAMILY_ALL.
+// Like DeleteRoutesForDevice, routes of the local routing table are never
+// removed. Routes which no longer exist are ignored. Deletion continues past
+// individual failures and the first error encountered is returned.
+func FlushRoutes(device string, family int) error {
+	link, err := defaultHandle.LinkByName(device)
+	if err != nil {
+		return fmt.Errorf("unable to lookup interface %s: %s", device, err)
+	}
+
+	deleted, failed, err := deleteRoutes(defaultHandle, routeFilter{
+		description: "of interface " + device,
+		family:      family,
+		route: netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Table:     unix.RT_TABLE_UNSPEC,
+		},
+		mask: netlink.RT_FILTER_OIF | netlink.RT_FILTER_TABLE,
+		keep: isLocalTableRoute,
+	}, firstError)
+	if deleted < 0 {
 		return err
-	} else {
-		route.getLogger().Info("Deleted route")
 	}
 
+	log.WithFields(logrus.Fields{
+		logfields.Interface: device,
+		"deleted":           deleted,
+		"failed":            failed,
+	}).Info("Flushed routes of device")
+
+	return err
+}
+
+// ByMask is used to sort an array of routes by mask, narrow first.
+type ByMask []Route
+
+func (a ByMask) Len() int {
+	return len(a)
+}
+
+func (a ByMask) Less(i, j int) bool {
+	lenA, _ := a[i].Prefix.Mask.Size()
+	lenB, _ := a[j].Prefix.Mask.Size()
+	return lenA > lenB
+}
+
+func (a ByMask) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func ipFamily(ip net.IP) int {
+	if ip.To4() == nil {
+		return netlink.FAMILY_V6
+	}
+
+	return netlink.FAMILY_V4
+}
+
+// defaultHandle is the netlink handle used to operate on the network namespace
+// of the calling process
+var defaultHandle = &netlink.Handle{}
+
+// getTable returns the routing table to use for the specified table number,
+// defaulting to the main routing table if no table is specified.
+func getTable(table int) int {
+	if table == 0 {
+		return unix.RT_TABLE_MAIN
+	}
+
+	return table
+}
+
+// ip6DefaultPriority is the metric assigned by the kernel to IPv6 routes which
+// are installed without a metric
+const ip6DefaultPriority = 1024
+
+// getType returns the route type, defaulting to a unicast route if no type is
+// specified
+func getType(routeType int) int {
+	if routeType == 0 {
+		return unix.RTN_UNICAST
+	}
+
+	return routeType
+}
+
+// getPriority returns the metric the kernel assigns to a route with the
+// specified destination and priority
+func getPriority(dst *net.IPNet, priority int) int {
+	if priority == 0 && dst != nil && dst.IP.To4() == nil {
+		return ip6DefaultPriority
+	}
+
+	return priority
+}
+
+// scopeEqual returns true if the route scopes a and b of routes with the
+// specified destination are considered equal. The kernel does not propagate
+// the scope of IPv6 routes and always reports them with universe scope, the
+// scope is therefore ignored for IPv6 routes.
+func scopeEqual(dst *net.IPNet, a, b netlink.Scope) bool {
+	if dst != nil && dst.IP.To4() == nil {
+		return true
+	}
+
+	return a == b
+}
+
+// lookup finds a particular route as specified by the filter which points
+// to the specified device. If link is nil, routes without a device such as
+// blackhole routes are considered. The filter route can have the following
+// fields set:
+//  - Dst
+//  - LinkIndex
+//  - Scope
+//  - Gw
+//  - Table
+//  - Priority
+//  - Type
+//  - MultiPath
+//  - Flags (onlink only)
+//  - Tos
+//  - AdvMSS
+//  - MTU
+func lookup(h *netlink.Handle, link netlink.Link, route *netlink.Route) *netlink.Route {
+	filter := &netlink.Route{
+		Table: getTable(route.Table),
+	}
+	filterMask := netlink.RT_FILTER_TABLE
+
+	if link != nil {
+		filter.LinkIndex = link.Attrs().Index
+		filterMask |= netlink.RT_FILTER_OIF
+	}
+
+	routes, err := h.RouteListFiltered(ipFamily(route.Dst.IP), filter, filterMask)
+	if err != nil {
+		return nil
+	}
+
+	return match(routes, link, route)
+}
+
+// match returns the route out of routes which matches the specified route
+// like lookup(). Routes of other tables or devices are skipped, routes do
+// thus not have to be filtered by the kernel beforehand.
+func match(routes []netlink.Route, link netlink.Link, route *netlink.Route) *netlink.Route {
+	for i := range routes {
+		r := &routes[i]
+		if getTable(r.Table) != getTable(route.Table) {
+			continue
+		}
+
+		if link != nil && r.LinkIndex != link.Attrs().Index {
+			continue
+		}
+
+		if routeSpecEqual(r, route) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// routeSpecEqual returns true if the routes a and b are considered equal.
+// Unset table, priority and type are considered equal to the defaults chosen
+// by the kernel. See lookup() for the list of fields compared.
+func routeSpecEqual(a, b *netlink.Route) bool {
+	if a.Dst == nil || b.Dst == nil {
+		if a.Dst != b.Dst {
+			return false
+		}
+	} else {
+		aMaskLen, aMaskBits := a.Dst.Mask.Size()
+		bMaskLen, bMaskBits := b.Dst.Mask.Size()
+		if aMaskLen != bMaskLen || aMaskBits != bMaskBits || !a.Dst.IP.Equal(b.Dst.IP) {
+			return false
+		}
+	}
+
+	// A zero interface index is set by routes which leave the choice of the
+	// device to the kernel
+	linkIndexEqual := a.LinkIndex == 0 || b.LinkIndex == 0 || a.LinkIndex == b.LinkIndex
+
+	return linkIndexEqual && scopeEqual(b.Dst, a.Scope, b.Scope) &&
+		a.Gw.Equal(b.Gw) &&
+		getTable(a.Table) == getTable(b.Table) &&
+		getPriority(a.Dst, a.Priority) == getPriority(b.Dst, b.Priority) &&
+		getType(a.Type) == getType(b.Type) &&
+		multiPathEqual(a.MultiPath, b.MultiPath) &&
+		a.Flags&int(netlink.FLAG_ONLINK) == b.Flags&int(netlink.FLAG_ONLINK) &&
+		a.Tos == b.Tos && a.AdvMSS == b.AdvMSS && a.MTU == b.MTU &&
+		encapEqual(a.Encap, b.Encap)
+}
+
+// encapEqual returns true if both encapsulations are unset or equal
+func encapEqual(a, b netlink.Encap) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Equal(b)
+}
+
+// getDevice returns the device of the nexthop of the multipath route r
+func (nh *NexthopInfo) getDevice(r *Route) string {
+	if nh.Device != "" {
+		return nh.Device
+	}
+
+	return r.Device
+}
+
+// Equal returns true if both routes are considered equal using the same
+// semantics ReplaceRoute uses to decide whether an installed route needs to
+// be replaced. Devices are compared by name. The MTU of both routes is
+// compared as specified, use Matches() to compare a route with an installed
+// route.
+func (r *Route) Equal(o *Route) bool {
+	a, b := r.getNetlinkRoute(), o.getNetlinkRoute()
+	return r.equal(o, &a, &b)
+}
+
+// Matches returns true if the installed route does not need to be replaced
+// when installing r. Unlike Equal(), the MTU of r and the AdvMSS derived from
+// it are compared as ReplaceRoute installs them, installed is compared as is.
+func (r *Route) Matches(installed *Route) bool {
+	a, b := r.getNetlinkRoute(), installed.getNetlinkRoute()
+	setRouteMTU(*r, &a)
+	return r.equal(installed, &a, &b)
+}
+
+// equal compares r and o given their netlink representations a and b
+func (r *Route) equal(o *Route, a, b *netlink.Route) bool {
+	if !r.isSpecialType() && r.Device != o.Device {
+		return false
+	}
+
+	if len(r.Nexthops) != len(o.Nexthops) {
+		return false
+	}
+
+	for i := range r.Nexthops {
+		if r.Nexthops[i].getDevice(r) != o.Nexthops[i].getDevice(o) {
+			return false
+		}
+	}
+
+	if (r.StrictSrc || o.StrictSrc) && !r.Local.Equal(o.Local) {
+		return false
+	}
+
+	return routeSpecEqual(a, b)
+}
+
+// DiffRoutes compares the desired routes with the actual routes, e.g. as
+// returned by ListRoutes(), and returns the routes which must be added and
+// the routes which must be deleted to reach the desired state. Desired
+// routes are compared with the actual routes with Route.Matches().
+func DiffRoutes(desired, actual []Route) (toAdd, toDelete []Route) {
+	toAdd = []Route{}
+	toDelete = []Route{}
+
+	for i := range desired {
+		found := false
+		for j := range actual {
+			if desired[i].Matches(&actual[j]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			toAdd = append(toAdd, desired[i])
+		}
+	}
+
+	for i := range actual {
+		found := false
+		for j := range desired {
+			if desired[j].Matches(&actual[i]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			toDelete = append(toDelete, actual[i])
+		}
+	}
+
+	return toAdd, toDelete
+}
+
+// multiPathEqual returns true if both lists of multipath nexthops consist of
+// the same nexthops in the same order
+func multiPathEqual(a, b []*netlink.NexthopInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].LinkIndex != b[i].LinkIndex || a[i].Hops != b[i].Hops ||
+			!a[i].Gw.Equal(b[i].Gw) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LookupRoute looks up the specified route and returns the installed route if
+// it exists. The route is matched based on prefix, device, scope, nexthop and
+// table, as well as the preferred source address if StrictSrc is set. If no
+// matching route is installed, nil is returned.
+func LookupRoute(route Route) (*Route, error) {
+	link, routeSpec, err := route.getLookupSpec(defaultHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	return route.fromLookup(lookup(defaultHandle, link, &routeSpec))
+}
+
+// getLookupSpec returns the link and the netlink route to match installed
+// routes against when looking up the route. The link is nil for routes which
+// do not point to a single known device.
+func (r *Route) getLookupSpec(h *netlink.Handle) (netlink.Link, netlink.Route, error) {
+	var link netlink.Link
+
+	routeSpec := r.getNetlinkRoute()
+
+	if len(r.Nexthops) > 0 {
+		if err := resolveMultiPath(h, *r, &routeSpec); err != nil {
+			return nil, routeSpec, err
+		}
+	} else if !r.isSpecialType() && !r.isGatewayOnly() {
+		var err error
+		link, err = r.getLink(h)
+		if err != nil {
+			return nil, routeSpec, err
+		}
+
+		routeSpec.LinkIndex = link.Attrs().Index
+	}
+
+	// A derived AdvMSS depends on the MTU selected when installing the
+	// route
+	setRouteMTU(*r, &routeSpec)
+
+	return link, routeSpec, nil
+}
+
+// srcMatches returns true if the preferred source address of the installed
+// route nlRoute matches the route. It is only compared if StrictSrc is set.
+func (r *Route) srcMatches(nlRoute *netlink.Route) bool {
+	return !r.StrictSrc || nlRoute.Src.Equal(r.Local)
+}
+
+// fromLookup converts nlRoute, the installed route matching r, into a Route.
+// nil is returned if nlRoute is nil.
+func (r *Route) fromLookup(nlRoute *netlink.Route) (*Route, error) {
+	if nlRoute == nil || !r.srcMatches(nlRoute) {
+		return nil, nil
+	}
+
+	if r.isGatewayOnly() {
+		// Report the device chosen by the kernel
+		result, err := FromNetlinkRoute(*nlRoute)
+		if err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+
+	result := newRouteFromNetlink(r.Device, nlRoute)
+	return &result, nil
+}
+
+// listAllNetlinkRoutes returns the routes of all devices and routing tables of
+// the specified address family
+func listAllNetlinkRoutes(h *netlink.Handle, family int) ([]netlink.Route, error) {
+	// The vendored netlink library only skips routes of tables other than
+	// the main table if no table filter is given. RT_TABLE_UNSPEC matches
+	// all tables.
+	filter := &netlink.Route{
+		Table: unix.RT_TABLE_UNSPEC,
+	}
+
+	nlRoutes, err := h.RouteListFiltered(family, filter, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list routes: %s", err)
+	}
+
+	return nlRoutes, nil
+}
+
+// routeSnapshot matches routes against the installed routes which are listed
+// once per address family on first use. Changes to the routes after listing
+// them are not reflected.
+type routeSnapshot struct {
+	handle *netlink.Handle
+	routes map[int][]netlink.Route
+}
+
+func newRouteSnapshot(h *netlink.Handle) *routeSnapshot {
+	return &routeSnapshot{
+		handle: h,
+		routes: map[int][]netlink.Route{},
+	}
+}
+
+// list returns the installed routes of the specified address family. The
+// routes are listed on first use.
+func (s *routeSnapshot) list(family int) ([]netlink.Route, error) {
+	nlRoutes, ok := s.routes[family]
+	if !ok {
+		var err error
+		nlRoutes, err = listAllNetlinkRoutes(s.handle, family)
+		if err != nil {
+			return nil, err
+		}
+		s.routes[family] = nlRoutes
+	}
+
+	return nlRoutes, nil
+}
+
+// lookup returns the installed route which matches route like lookup()
+func (s *routeSnapshot) lookup(link netlink.Link, route *netlink.Route) (*netlink.Route, error) {
+	nlRoutes, err := s.list(ipFamily(route.Dst.IP))
+	if err != nil {
+		return nil, err
+	}
+
+	return match(nlRoutes, link, route), nil
+}
+
+// lookupPrevious returns the installed route which is overwritten when
+// installing routeSpec like lookupPrevious(). If the routes cannot be listed,
+// nil is returned.
+func (s *routeSnapshot) lookupPrevious(routeSpec *netlink.Route) *netlink.Route {
+	nlRoutes, err := s.list(ipFamily(routeSpec.Dst.IP))
+	if err != nil {
+		return nil
+	}
+
+	return matchPrevious(nlRoutes, routeSpec)
+}
+
+// isInstalled returns true if the route as well as its L2 nexthop route are
+// installed. Routes with a nexthop neighbor entry and multipath routes are
+// never reported as installed as these require more than a single route.
+func (s *routeSnapshot) isInstalled(route *Route) (bool, error) {
+	if route.NexthopMAC != nil || len(route.Nexthops) > 0 {
+		return false, nil
+	}
+
+	link, routeSpec, err := route.getLookupSpec(s.handle)
+	if err != nil {
+		return false, err
+	}
+
+	if nlRoute, err := s.lookup(link, &routeSpec); nlRoute == nil || err != nil {
+		return false, err
+	} else if !route.srcMatches(nlRoute) {
+		return false, nil
+	}
+
+	if link != nil && route.requiresNexthopRoute() {
+		nexthopRoute := createNexthopRoute(link, route.getNexthopAsIPNet(), route.Table, route.NexthopScope)
+		if nlRoute, err := s.lookup(link, nexthopRoute); nlRoute == nil || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// LookupRoutes looks up all specified routes like LookupRoute. The installed
+// routes are listed once per address family instead of once per route. The
+// returned slice contains the installed route for each of the specified
+// routes in the same order, or nil if the route is not installed.
+func LookupRoutes(routes []Route) ([]*Route, error) {
+	snapshot := newRouteSnapshot(defaultHandle)
+	results := make([]*Route, len(routes))
+
+	for i := range routes {
+		route := &routes[i]
+
+		link, routeSpec, err := route.getLookupSpec(defaultHandle)
+		if err != nil {
+			return nil, err
+		}
+
+		nlRoute, err := snapshot.lookup(link, &routeSpec)
+		if err != nil {
+			return nil, err
+		}
+
+		results[i], err = route.fromLookup(nlRoute)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// RouteExists returns true if a route matching the specified route is
+// installed. The route is matched like in LookupRoute, no route is modified.
+func RouteExists(route Route) (bool, error) {
+	installed, err := LookupRoute(route)
+	if err != nil {
+		return false, err
+	}
+
+	return installed != nil, nil
+}
+
+// ResolveRoute returns the route the kernel selects to forward packets to dst
+// like "ip route get <dst>". The prefix of the returned route is the host
+// prefix of dst rather than the prefix of the matching routing table entry.
+func ResolveRoute(dst net.IP) (*Route, error) {
+	nlRoutes, err := netlink.RouteGet(dst)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve route to %s: %s", dst, err)
+	}
+
+	if len(nlRoutes) == 0 {
+		return nil, fmt.Errorf("no route to %s", dst)
+	}
+
+	result, err := FromNetlinkRoute(nlRoutes[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// getNexthopScope returns the scope of the L2 nexthop route. The link scope is
+// used if no scope is specified.
+func getNexthopScope(scope netlink.Scope) netlink.Scope {
+	if scope == 0 {
+		return netlink.SCOPE_LINK
+	}
+
+	return scope
+}
+
+func createNexthopRoute(link netlink.Link, routerNet *net.IPNet, table int, scope netlink.Scope) *netlink.Route {
+	// This is the L2 route which makes router IP available behind the
+	// interface. It must reside in the same table as the route using the
+	// router IP as nexthop for the kernel to be able to resolve it.
+	rt := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       routerNet,
+		Table:     table,
+	}
+
+	// The scope of IPv6 routes is not propagated by the kernel, lookup()
+	// ignores the scope for IPv6 routes accordingly
+	rt.Scope = getNexthopScope(scope)
+
+	return rt
+}
+
+// replaceNexthopRoute verifies that the L2 route for the router IP which is
+// used as nexthop for all node routes is properly installed. If unavailable or
+// incorrect, it will be replaced with the proper L2 route. An added route is
+// recorded in the journal unless the journal is nil.
+func replaceNexthopRoute(h *netlink.Handle, link netlink.Link, routerNet *net.IPNet, table int, scope netlink.Scope, journal *routeJournal) (bool, error) {
+	route := createNexthopRoute(link, routerNet, table, scope)
+	if lookup(h, link, route) == nil {
+		scopedLog := log.WithField(logfields.Route, route)
+
+		if err := replaceWithRetry(h, route); err != nil {
+			scopedLog.WithError(err).Error("Unable to add L2 nexthop route")
+			return false, fmt.Errorf("unable to add L2 nexthop route: %s", err)
+		}
+
+		scopedLog.Info("Added L2 nexthop route")
+		journal.addNexthopRoute(route)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// hasNexthop returns true if the L2 nexthop route and the neighbor entry of the
+// nexthop which are installed along with the route exist. Routes which do not
+// require either are always reported as complete.
+func hasNexthop(h *netlink.Handle, route *Route) bool {
+	if route.isSpecialType() || route.isGatewayOnly() || len(route.Nexthops) > 0 {
+		return true
+	}
+
+	if !route.requiresNexthopRoute() && route.NexthopMAC == nil {
+		return true
+	}
+
+	link, err := route.getLink(h)
+	if err != nil {
+		return false
+	}
+
+	if route.requiresNexthopRoute() {
+		nexthopRoute := createNexthopRoute(link, route.getNexthopAsIPNet(), route.Table, route.NexthopScope)
+		if lookup(h, link, nexthopRoute) == nil {
+			return false
+		}
+	}
+
+	if route.NexthopMAC != nil {
+		neighbor := Neighbor{IP: *route.Nexthop, MAC: route.NexthopMAC}
+		if lookupNeighbor(h, neighbor.getNetlinkNeigh(link)) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// deleteNexthopRoute deletes
+func deleteNexthopRoute(h *netlink.Handle, link netlink.Link, routerNet *net.IPNet, table int, scope netlink.Scope) error {
+	route := createNexthopRoute(link, routerNet, table, scope)
+	if err := h.RouteDel(route); err != nil {
+		return fmt.Errorf("unable to delete L2 nexthop route: %s", err)
+	}
+
+	return nil
+}
+
+// replaceSpecialRoute installs a route of special type which does not point to
+// a device, e.g. a blackhole route, if necessary
+func replaceSpecialRoute(h *netlink.Handle, route Route, stats *routeStatistics) (bool, error) {
+	routeSpec := route.getNetlinkRoute()
+
+	return replaceRouteSpec(h, nil, &routeSpec, route.StrictSrc, stats)
+}
+
+// resolveMultiPath resolves the device of each multipath nexthop of the route
+// and sets the interface index of the nexthops in routeSpec accordingly
+func resolveMultiPath(h *netlink.Handle, route Route, routeSpec *netlink.Route) error {
+	for i, nh := range route.Nexthops {
+		var (
+			link netlink.Link
+			err  error
+		)
+
+		if nh.Device != "" {
+			link, err = h.LinkByName(nh.Device)
+			if err != nil {
+				err = fmt.Errorf("unable to lookup interface %s: %s", nh.Device, err)
+			}
+		} else {
+			link, err = route.getLink(h)
+		}
+		if err != nil {
+			return err
+		}
+
+		routeSpec.MultiPath[i].LinkIndex = link.Attrs().Index
+	}
+
+	return nil
+}
+
+// setRouteMTU sets the MTU of routeSpec if the route requires an MTU. An
+// explicit MTU of the route is left untouched.
+func setRouteMTU(route Route, routeSpec *netlink.Route) {
+	if routeSpec.MTU != 0 && !route.MTUExplicit {
+		// If the route includes the local address, then the route is for
+		// local containers and we can use a high MTU for transmit. Otherwise,
+		// it needs to be able to fit within the MTU of tunnel devices.
+		if route.Prefix.Contains(route.Local) {
+			routeSpec.MTU = mtu.GetDeviceMTU()
+		} else {
+			routeSpec.MTU = mtu.GetRouteMTU()
+		}
+	}
+
+	// The segment size must fit into the MTU selected above
+	routeSpec.AdvMSS = route.getAdvMSS(routeSpec.MTU)
+}
+
+// replaceMultipathRoute installs a route with multiple nexthops if necessary.
+// The L2 nexthop route is installed for each of the nexthops.
+func replaceMultipathRoute(h *netlink.Handle, route Route, stats *routeStatistics, journal *routeJournal) (bool, error) {
+	routeSpec := route.getNetlinkRoute()
+
+	stats.linkLookup.Start()
+	err := resolveMultiPath(h, route, &routeSpec)
+	stats.linkLookup.End()
+	if err != nil {
+		return false, err
+	}
+
+	stats.nexthopRoute.Start()
+	for _, nh := range routeSpec.MultiPath {
+		link, err := h.LinkByIndex(nh.LinkIndex)
+		if err != nil {
+			stats.nexthopRoute.End()
+			return false, fmt.Errorf("unable to lookup interface with index %d: %s", nh.LinkIndex, err)
+		}
+
+		if _, err := replaceNexthopRoute(h, link, ipAsIPNet(nh.Gw), route.Table, route.NexthopScope, journal); err != nil {
+			stats.nexthopRoute.End()
+			return false, fmt.Errorf("unable to add nexthop route: %s", err)
+		}
+	}
+	stats.nexthopRoute.End()
+
+	setRouteMTU(route, &routeSpec)
+
+	// Multipath routes do not point to a single device
+	return replaceRouteSpec(h, nil, &routeSpec, route.StrictSrc, stats)
+}
+
+// Validate checks the route for configuration errors which would otherwise
+// only be reported by the kernel with an opaque error
+func (r *Route) Validate() error {
+	if len(r.Prefix.IP) == 0 || len(r.Prefix.Mask) == 0 {
+		return fmt.Errorf("invalid route: prefix %s must specify IP and mask", r.Prefix.String())
+	}
+
+	family := ipFamily(r.Prefix.IP)
+	if r.Nexthop != nil && ipFamily(*r.Nexthop) != family {
+		return fmt.Errorf("invalid route %s: address family of nexthop %s does not match prefix",
+			r.Prefix.String(), r.Nexthop.String())
+	}
+
+	if r.Local != nil && ipFamily(r.Local) != family {
+		return fmt.Errorf("invalid route %s: address family of local address %s does not match prefix",
+			r.Prefix.String(), r.Local.String())
+	}
+
+	// The device of a multipath route is only used by nexthops without a
+	// device. Routes with a single nexthop may leave the choice of the
+	// device to the kernel.
+	deviceRequired := len(r.Nexthops) == 0 && !r.isSpecialType() && r.Nexthop == nil
+	for _, nh := range r.Nexthops {
+		if ipFamily(nh.IP) != family {
+			return fmt.Errorf("invalid route %s: address family of nexthop %s does not match prefix",
+				r.Prefix.String(), nh.IP.String())
+		}
+
+		if nh.Device == "" {
+			deviceRequired = true
+		}
+	}
+
+	if deviceRequired && r.Device == "" && r.LinkIndex == 0 {
+		return fmt.Errorf("invalid route %s: device or link index must be specified", r.Prefix.String())
+	}
+
+	if r.isAddressType() && (r.Nexthop != nil || len(r.Nexthops) > 0) {
+		return fmt.Errorf("invalid route %s: local and broadcast routes cannot have a nexthop", r.Prefix.String())
+	}
+
+	if r.NexthopMAC != nil && (r.Nexthop == nil || r.isGatewayOnly()) {
+		return fmt.Errorf("invalid route %s: nexthop MAC requires a nexthop and a device", r.Prefix.String())
+	}
+
+	return nil
+}
+
+// checkNexthopMAC returns an error if the route specifies a nexthop MAC but
+// points to a loopback or point-to-point link. Validate cannot check this as
+// it does not resolve the device. The kernel keys all neighbor entries of such
+// links on the unspecified address, the neighbor entry of the nexthop would
+// thus never be found and be set again on every replacement.
+func (r *Route) checkNexthopMAC(link netlink.Link) error {
+	if r.NexthopMAC != nil && link.Attrs().Flags&(net.FlagLoopback|net.FlagPointToPoint) != 0 {
+		return fmt.Errorf("invalid route %s: nexthop MAC requires a device with link layer addresses, %s is a loopback or point-to-point device",
+			r.Prefix.String(), link.Attrs().Name)
+	}
+
+	return nil
+}
+
+// checkFromPrefix returns an error if the route matches on the source prefix
+// which cannot be expressed by a plain route
+func (r *Route) checkFromPrefix() error {
+	if r.FromPrefix != nil {
+		return fmt.Errorf("route %s cannot match on source prefix %s, install it into a separate table selected by a rule instead",
+			r.Prefix.String(), r.FromPrefix.String())
+	}
+
+	return nil
+}
+
+// ReplaceMaxAttempts is the maximum number of attempts to install a route if
+// the kernel reports a transient error
+var ReplaceMaxAttempts = 3
+
+// routeReplace installs the route via the netlink handle. It is replaced in
+// unit tests to fake netlink errors.
+var routeReplace = func(h *netlink.Handle, route *netlink.Route) error {
+	return h.RouteReplace(route)
+}
+
+// isTransient returns true if the error returned by netlink indicates that
+// the operation may succeed if retried
+func isTransient(err error) bool {
+	return err == unix.EBUSY || err == unix.EAGAIN
+}
+
+// replaceWithRetry installs the route and retries with a small backoff for up
+// to ReplaceMaxAttempts attempts as long as the kernel reports a transient
+// error. The error of the last attempt is returned.
+func replaceWithRetry(h *netlink.Handle, route *netlink.Route) error {
+	boff := backoff.Exponential{
+		Min:  time.Duration(10) * time.Millisecond,
+		Max:  time.Duration(100) * time.Millisecond,
+		Name: "route-replace",
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := routeReplace(h, route)
+		if err == nil || !isTransient(err) || attempt >= ReplaceMaxAttempts {
+			return err
+		}
+
+		log.WithError(err).WithFields(logrus.Fields{
+			logfields.Route: route,
+			"attempt":       attempt,
+		}).Debug("Retrying route replace after transient error")
+		boff.Wait()
+	}
+}
+
+// routeSpecDiff returns the fields which differ between the installed route
+// previous and routeSpec, which is about to replace it. The value of each
+// field is rendered as "<previous> -> <new>".
+func routeSpecDiff(previous, routeSpec *netlink.Route) logrus.Fields {
+	diff := logrus.Fields{}
+
+	if !previous.Gw.Equal(routeSpec.Gw) {
+		diff["gw"] = fmt.Sprintf("%s -> %s", previous.Gw, routeSpec.Gw)
+	}
+	if previous.MTU != routeSpec.MTU {
+		diff["mtu"] = fmt.Sprintf("%d -> %d", previous.MTU, routeSpec.MTU)
+	}
+	if !scopeEqual(routeSpec.Dst, previous.Scope, routeSpec.Scope) {
+		diff["scope"] = fmt.Sprintf("%s -> %s", scopeName(previous.Scope), scopeName(routeSpec.Scope))
+	}
+	priority := getPriority(routeSpec.Dst, routeSpec.Priority)
+	if previous.Priority != priority {
+		diff["priority"] = fmt.Sprintf("%d -> %d", previous.Priority, priority)
+	}
+
+	return diff
+}
+
+// replaceRouteSpec installs routeSpec pointing to link unless an equal route
+// is already installed. If strictSrc is true, an installed route with a
+// different preferred source address is replaced.
+func replaceRouteSpec(h *netlink.Handle, link netlink.Link, routeSpec *netlink.Route, strictSrc bool, stats *routeStatistics) (bool, error) {
+	stats.routeLookup.Start()
+	installed := lookup(h, link, routeSpec)
+	stats.routeLookup.End()
+
+	if installed == nil || (strictSrc && !installed.Src.Equal(routeSpec.Src)) {
+		// Listing the routes again is only worth it if the diff is
+		// actually logged
+		if log.Logger.Level >= logrus.DebugLevel {
+			if previous := lookupPrevious(h, routeSpec); previous != nil {
+				log.WithFields(routeSpecDiff(previous, routeSpec)).
+					WithField("prefix", routeSpec.Dst.String()).
+					Debug("Replacing route")
+			}
+		}
+
+		stats.routeUpdate.Start()
+		err := replaceWithRetry(h, routeSpec)
+		stats.routeUpdate.End()
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func replaceRoute(h *netlink.Handle, route Route) (bool, error) {
+	return replaceRouteJournaled(h, route, nil)
+}
+
+// replaceRouteJournaled adds or replaces the route like replaceRoute and
+// records the L2 nexthop routes and neighbor entries added in the journal
+// unless the journal is nil
+func replaceRouteJournaled(h *netlink.Handle, route Route, journal *routeJournal) (bool, error) {
+	stats := &routeStatistics{}
+	defer stats.record(&lastReplaceStats, route, "replace")
+
+	if err := route.Validate(); err != nil {
+		return false, err
+	}
+
+	if err := route.checkFromPrefix(); err != nil {
+		return false, err
+	}
+
+	// The cache only reflects the host network namespace. It does not
+	// cover the L2 nexthop route and the neighbor entry of the nexthop,
+	// these are verified even if the route itself is cached.
+	if h == defaultHandle {
+		if installedRoutes.contains(&route) && hasNexthop(h, &route) {
+			return false, nil
+		}
+
+		replaced, err := replaceRouteUncached(h, route, stats, journal)
+		if replaced {
+			installedRoutes.invalidate(route.Prefix, route.Table)
+		}
+		return replaced, err
+	}
+
+	return replaceRouteUncached(h, route, stats, journal)
+}
+
+// replaceRouteUncached adds or replaces the route if it differs from the route
+// installed in the kernel
+func replaceRouteUncached(h *netlink.Handle, route Route, stats *routeStatistics, journal *routeJournal) (bool, error) {
+	if route.isSpecialType() {
+		return replaceSpecialRoute(h, route, stats)
+	}
+
+	if len(route.Nexthops) > 0 {
+		return replaceMultipathRoute(h, route, stats, journal)
+	}
+
+	// The kernel resolves the device and the nexthop must thus already
+	// be reachable, no L2 nexthop route is installed
+	if route.isGatewayOnly() {
+		routeSpec := route.getNetlinkRoute()
+		setRouteMTU(route, &routeSpec)
+		return replaceRouteSpec(h, nil, &routeSpec, route.StrictSrc, stats)
+	}
+
+	stats.linkLookup.Start()
+	link, err := route.getLink(h)
+	stats.linkLookup.End()
+	if err != nil {
+		return false, err
+	}
+
+	if err := route.checkNexthopMAC(link); err != nil {
+		return false, err
+	}
+
+	if route.requiresNexthopRoute() {
+		routerNet := route.getNexthopAsIPNet()
+		stats.nexthopRoute.Start()
+		_, err := replaceNexthopRoute(h, link, routerNet, route.Table, route.NexthopScope, journal)
+		stats.nexthopRoute.End()
+		if err != nil {
+			return false, fmt.Errorf("unable to add nexthop route: %s", err)
+		}
+	}
+
+	if route.NexthopMAC != nil {
+		neighbor := Neighbor{IP: *route.Nexthop, MAC: route.NexthopMAC, Device: link.Attrs().Name}
+		added, err := replaceNeighbor(h, link, neighbor)
+		if err != nil {
+			return false, fmt.Errorf("unable to add nexthop neighbor: %s", err)
+		}
+		if added {
+			journal.addNeighbor(neighbor.getNetlinkNeigh(link))
+		}
+	}
+
+	routeSpec := route.getNetlinkRoute()
+	routeSpec.LinkIndex = link.Attrs().Index
+
+	setRouteMTU(route, &routeSpec)
+
+	return replaceRouteSpec(h, link, &routeSpec, route.StrictSrc, stats)
+}
+
+// ReplaceRoute adds or replaces the specified route if necessary. The route is
+// installed into the routing table specified by route.Table or the main
+// routing table if none is specified.
+func ReplaceRoute(route Route) error {
+	replaced, err := replaceRoute(defaultHandle, route)
+	if err != nil {
+		route.getLogger().WithError(err).Error("Unable to add route")
+		return err
+	} else if replaced {
+		route.getLogger().Info("Updated route")
+	}
+
+	return nil
+}
+
+// lookupPrevious returns the installed route which is overwritten when
+// installing routeSpec. The kernel identifies a route by its prefix, table,
+// priority and TOS.
+func lookupPrevious(h *netlink.Handle, routeSpec *netlink.Route) *netlink.Route {
+	filter := &netlink.Route{
+		Table: getTable(routeSpec.Table),
+	}
+
+	routes, err := h.RouteListFiltered(ipFamily(routeSpec.Dst.IP), filter, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil
+	}
+
+	return matchPrevious(routes, routeSpec)
+}
+
+// matchPrevious returns the route out of routes which is overwritten when
+// installing routeSpec like lookupPrevious(). Routes of other tables are
+// skipped, routes do thus not have to be filtered by the kernel beforehand.
+func matchPrevious(routes []netlink.Route, routeSpec *netlink.Route) *netlink.Route {
+	for _, r := range routes {
+		if r.Dst == nil || getTable(r.Table) != getTable(routeSpec.Table) {
+			continue
+		}
+
+		aMaskLen, aMaskBits := r.Dst.Mask.Size()
+		bMaskLen, bMaskBits := routeSpec.Dst.Mask.Size()
+		if aMaskLen == bMaskLen && aMaskBits == bMaskBits &&
+			r.Dst.IP.Equal(routeSpec.Dst.IP) &&
+			r.Priority == getPriority(routeSpec.Dst, routeSpec.Priority) &&
+			r.Tos == routeSpec.Tos {
+			return &r
+		}
+	}
+
+	return nil
+}
+
+// ReplaceRouteWithPrevious adds or replaces the specified route like
+// ReplaceRoute and additionally returns the route which was installed before.
+// If no route existed, previous is nil. If the installed route is already
+// equal to the specified route, replaced is false and previous is the
+// installed route.
+func ReplaceRouteWithPrevious(route Route) (previous *Route, replaced bool, err error) {
+	routeSpec := route.getNetlinkRoute()
+	if nlRoute := lookupPrevious(defaultHandle, &routeSpec); nlRoute != nil {
+		// The link of the previous route may be gone already, the
+		// route is reported with a synthetic device name in that case
+		prev, _ := FromNetlinkRoute(*nlRoute)
+		previous = &prev
+	}
+
+	replaced, err = replaceRoute(defaultHandle, route)
+	if err != nil {
+		route.getLogger().WithError(err).Error("Unable to add route")
+		return nil, false, err
+	} else if replaced {
+		route.getLogger().Info("Updated route")
+	}
+
+	return previous, replaced, nil
+}
+
+// ReplaceRouteDryRun returns the "ip route ..." commands equivalent to the
+// changes ReplaceRoute would apply for the specified route, including the L2
+// nexthop routes and the MTU selection, without modifying any routes.
+func ReplaceRouteDryRun(route Route) ([]string, error) {
+	var commands []string
+
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := route.checkFromPrefix(); err != nil {
+		return nil, err
+	}
+
+	if route.isSpecialType() || route.isGatewayOnly() {
+		return []string{strings.Join(route.ToIPCommand(""), " ")}, nil
+	}
+
+	routeSpec := route.getNetlinkRoute()
+	setRouteMTU(route, &routeSpec)
+	route.MTU = routeSpec.MTU
+
+	nexthopCommand := func(ip net.IP, dev string) string {
+		nexthopRoute := Route{
+			Prefix: *ipAsIPNet(ip),
+			Scope:  getNexthopScope(route.NexthopScope),
+			Table:  route.Table,
+		}
+		return strings.Join(nexthopRoute.ToIPCommand(dev), " ")
+	}
+
+	if len(route.Nexthops) > 0 {
+		if err := resolveMultiPath(defaultHandle, route, &routeSpec); err != nil {
+			return nil, err
+		}
+
+		// Do not modify the nexthops of the caller
+		nexthops := make([]NexthopInfo, len(route.Nexthops))
+		copy(nexthops, route.Nexthops)
+		route.Nexthops = nexthops
+
+		for i, nh := range routeSpec.MultiPath {
+			link, err := netlink.LinkByIndex(nh.LinkIndex)
+			if err != nil {
+				return nil, fmt.Errorf("unable to lookup interface with index %d: %s", nh.LinkIndex, err)
+			}
+
+			route.Nexthops[i].Device = link.Attrs().Name
+			commands = append(commands, nexthopCommand(nh.Gw, link.Attrs().Name))
+		}
+
+		// The device of each nexthop is set explicitly
+		return append(commands, strings.Join(route.ToIPCommand(""), " ")), nil
+	}
+
+	link, err := route.getLink(defaultHandle)
+	if err != nil {
+		return nil, err
+	}
+	dev := link.Attrs().Name
+
+	if err := route.checkNexthopMAC(link); err != nil {
+		return nil, err
+	}
+
+	if route.requiresNexthopRoute() {
+		commands = append(commands, nexthopCommand(*route.Nexthop, dev))
+	}
+
+	return append(commands, strings.Join(route.ToIPCommand(dev), " ")), nil
+}
+
+// ReplaceRouteCtx adds or replaces the specified route if necessary like
+// ReplaceRoute. If ctx is cancelled or its deadline is exceeded before the
+// route has been installed, the error of the context is returned. The route
+// is not touched if ctx is done already. Note that the underlying netlink
+// operation may still complete after the context has been cancelled, only the
+// caller is unblocked.
+func ReplaceRouteCtx(ctx context.Context, route Route) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-ReplaceRouteAsync(route):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReplaceRouteAsync adds or replaces the specified route like ReplaceRoute in
+// the background. The result is sent to the returned channel which is closed
+// afterwards. The channel is buffered, the caller is not required to receive
+// the result.
+//
+// Any number of routes can be replaced concurrently. Each netlink request is
+// sent over a socket of its own and the L2 nexthop routes and neighbor
+// entries shared by routes are installed idempotently. Concurrent
+// replacements of routes with the same prefix and table are applied in
+// unspecified order.
+func ReplaceRouteAsync(route Route) <-chan error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- ReplaceRoute(route)
+		close(errChan)
+	}()
+
+	return errChan
+}
+
+// journalEntry is a route installed by a batch along with the route it
+// replaced, if any
+type journalEntry struct {
+	route    Route
+	previous *netlink.Route
+}
+
+// routeJournal records the routes installed by a batch of route replacements
+// so they can be rolled back if the batch fails
+type routeJournal struct {
+	routes        []journalEntry
+	nexthopRoutes []netlink.Route
+	neighbors     []netlink.Neigh
+}
+
+// addRoute records route as installed, replacing the installed route previous
+// unless previous is nil
+func (j *routeJournal) addRoute(route Route, previous *netlink.Route) {
+	j.routes = append(j.routes, journalEntry{route: route, previous: previous})
+}
+
+// addNexthopRoute records an added L2 nexthop route. A nil journal records
+// nothing.
+func (j *routeJournal) addNexthopRoute(route *netlink.Route) {
+	if j != nil {
+		j.nexthopRoutes = append(j.nexthopRoutes, *route)
+	}
+}
+
+// addNeighbor records an added nexthop neighbor entry. A nil journal records
+// nothing.
+func (j *routeJournal) addNeighbor(neigh *netlink.Neigh) {
+	if j != nil {
+		j.neighbors = append(j.neighbors, *neigh)
+	}
+}
+
+// rollback reverts the recorded changes in reverse order. Routes which
+// replaced an installed route are restored to the previous route, all other
+// routes are removed. The L2 nexthop routes and the neighbor entries are
+// removed last. The errors of all failed operations are returned.
+func (j *routeJournal) rollback(h *netlink.Handle) []string {
+	errs := []string{}
+
+	for i := len(j.routes) - 1; i >= 0; i-- {
+		entry := &j.routes[i]
+		scopedLog := entry.route.getLogger()
+
+		if entry.previous == nil {
+			if err := deleteRoute(h, entry.route); err != nil && !isNotFound(err) {
+				scopedLog.WithError(err).Error("Unable to roll back route")
+				errs = append(errs, fmt.Sprintf("unable to roll back route %s: %s", entry.route.Prefix.String(), err))
+			}
+			continue
+		}
+
+		if h == defaultHandle {
+			installedRoutes.invalidate(entry.route.Prefix, entry.route.Table)
+		}
+
+		if err := replaceWithRetry(h, entry.previous); err != nil {
+			scopedLog.WithError(err).Error("Unable to restore previous route")
+			errs = append(errs, fmt.Sprintf("unable to restore previous route %s: %s", entry.route.Prefix.String(), err))
+		}
+	}
+
+	for i := len(j.nexthopRoutes) - 1; i >= 0; i-- {
+		nexthopRoute := &j.nexthopRoutes[i]
+		if err := routeDel(h, nexthopRoute); err != nil && !isNotFound(err) {
+			log.WithError(err).WithField(logfields.Route, nexthopRoute).Error("Unable to roll back L2 nexthop route")
+			errs = append(errs, fmt.Sprintf("unable to roll back L2 nexthop route %s: %s", nexthopRoute.Dst, err))
+		}
+	}
+
+	for i := len(j.neighbors) - 1; i >= 0; i-- {
+		neigh := &j.neighbors[i]
+		if err := neighDel(h, neigh); err != nil && !isNotFound(err) {
+			log.WithError(err).WithField("ip", neigh.IP).Error("Unable to roll back nexthop neighbor")
+			errs = append(errs, fmt.Sprintf("unable to roll back nexthop neighbor %s: %s", neigh.IP, err))
+		}
+	}
+
+	return errs
+}
+
+// ReplaceRoutes adds or replaces all specified routes if necessary. The routes
+// are installed in order of their prefix length, narrow first. If any of the
+// routes cannot be installed, the changes applied by this call are rolled
+// back: routes which replaced an installed route are restored to the
+// previous route, all other routes as well as the L2 nexthop routes added are
+// removed again.
+func ReplaceRoutes(routes []Route) error {
+	sorted := make([]Route, len(routes))
+	copy(sorted, routes)
+	sort.Stable(ByMask(sorted))
+
+	// Routes which are installed already are skipped without listing the
+	// routes of the device for each of them. Invalid routes and routes
+	// which cannot be looked up are passed on to replaceRoute() to report
+	// the error.
+	snapshot := newRouteSnapshot(defaultHandle)
+
+	journal := &routeJournal{}
+	for _, route := range sorted {
+		var previous *netlink.Route
+		if route.Validate() == nil && route.checkFromPrefix() == nil {
+			if installed, _ := snapshot.isInstalled(&route); installed {
+				continue
+			}
+
+			// The route installed before is restored on rollback.
+			// Changes of earlier routes of the batch are not reflected
+			// by the snapshot, routes overwriting each other are thus
+			// all restored to the route installed before the batch.
+			routeSpec := route.getNetlinkRoute()
+			previous = snapshot.lookupPrevious(&routeSpec)
+		}
+
+		replaced, err := replaceRouteJournaled(defaultHandle, route, journal)
+		if err != nil {
+			route.getLogger().WithError(err).Error("Unable to add route")
+			errs := []string{fmt.Sprintf("unable to add route %s: %s", route.Prefix.String(), err)}
+			errs = append(errs, journal.rollback(defaultHandle)...)
+
+			return fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+
+		if replaced {
+			route.getLogger().Info("Updated route")
+			journal.addRoute(route, previous)
+		}
+	}
+
+	return nil
+}
+
+// routeDel removes the route via the netlink handle. It is replaced in unit
+// tests to fake netlink errors.
+var routeDel = func(h *netlink.Handle, route *netlink.Route) error {
+	return h.RouteDel(route)
+}
+
+func deleteRoute(h *netlink.Handle, route Route) error {
+	stats := &routeStatistics{}
+	defer stats.record(&lastDeleteStats, route, "delete")
+
+	if h == defaultHandle {
+		installedRoutes.invalidate(route.Prefix, route.Table)
+	}
+
+	// Special, multipath and gateway only routes do not point to a
+	// single known device
+	if route.isSpecialType() || len(route.Nexthops) > 0 || route.isGatewayOnly() {
+		routeSpec := netlink.Route{
+			Dst:      &route.Prefix,
+			Table:    route.Table,
+			Priority: route.Priority,
+			Type:     route.Type,
+		}
+
+		stats.routeUpdate.Start()
+		defer stats.routeUpdate.End()
+		return routeDel(h, &routeSpec)
+	}
+
+	stats.linkLookup.Start()
+	link, err := route.getLink(h)
+	stats.linkLookup.End()
+	if err != nil {
+		return err
+	}
+
+	// Deletion of routes with Nexthop or Local set fails for IPv6.
+	// Therefore do not use getNetlinkRoute(). The type must be specified
+	// to delete local and broadcast routes as netlink defaults to unicast.
+	routeSpec := netlink.Route{
+		Dst:       &route.Prefix,
+		LinkIndex: link.Attrs().Index,
+		Table:     route.Table,
+		Priority:  route.Priority,
+		Type:      route.Type,
+	}
+
+	// Scope and TOS can only be specified for IPv4. The kernel does not
+	// store a scope for IPv6 routes and ignores it when matching the
+	// route to delete, IPv6 routes installed with a scope are thus
+	// removed all the same.
+	if route.Prefix.IP.To4() != nil {
+		routeSpec.Scope = route.getScope()
+		routeSpec.Tos = route.Tos
+	}
+
+	stats.routeUpdate.Start()
+	err = routeDel(h, &routeSpec)
+	stats.routeUpdate.End()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRoute removes a route from the routing table specified by route.Table
+// or the main routing table if none is specified. Deleting a route which does
+// not exist is not considered an error.
+func DeleteRoute(route Route) error {
+	_, err := DeleteRouteWithResult(route)
+	return err
+}
+
+// prefixEqual returns true if the destination dst of a netlink route equals
+// the prefix
+func prefixEqual(dst, prefix *net.IPNet) bool {
+	if dst == nil {
+		return false
+	}
+
+	dstLen, dstBits := dst.Mask.Size()
+	prefixLen, prefixBits := prefix.Mask.Size()
+	return dstLen == prefixLen && dstBits == prefixBits && dst.IP.Equal(prefix.IP)
+}
+
+// DeleteRouteByPrefix removes all routes to the specified prefix which point
+// to the specified device from the routing table specified by table or the
+// main routing table if table is 0. Nexthop, scope and all other attributes
+// of the routes are ignored. Deleting a route which does not exist is not
+// considered an error.
+func DeleteRouteByPrefix(prefix net.IPNet, device string, table int) error {
+	link, err := defaultHandle.LinkByName(device)
+	if err != nil {
+		return fmt.Errorf("unable to lookup interface %s: %s", device, err)
+	}
+
+	scopedLog := log.WithFields(logrus.Fields{
+		"prefix":            prefix.String(),
+		"table":             table,
+		logfields.Interface: device,
+	})
+
+	filter := routeFilter{
+		description: "of interface " + device,
+		family:      ipFamily(prefix.IP),
+		route: netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Table:     getTable(table),
+		},
+		mask: netlink.RT_FILTER_OIF | netlink.RT_FILTER_TABLE,
+		keep: func(r *netlink.Route) bool {
+			return !prefixEqual(r.Dst, &prefix)
+		},
+	}
+
+	deleted, _, err := deleteRoutes(defaultHandle, filter, combineErrors)
+	if err != nil {
+		if deleted >= 0 {
+			scopedLog.WithError(err).Error("Unable to delete route")
+		}
+		return err
+	}
+
+	if deleted > 0 {
+		scopedLog.WithField("count", deleted).Info("Deleted route")
+	}
+
+	return nil
+}
+
+// isNotFound returns true if the error returned by netlink indicates that the
+// route or rule to delete does not exist
+func isNotFound(err error) bool {
+	return err == unix.ESRCH || err == unix.ENOENT
+}
+
+// DeleteRouteCtx removes a route like DeleteRoute. If ctx is cancelled or its
+// deadline is exceeded before the route has been removed, the error of the
+// context is returned. The route is not touched if ctx is done already. Note
+// that the underlying netlink operation may still complete after the context
+// has been cancelled, only the caller is unblocked.
+func DeleteRouteCtx(ctx context.Context, route Route) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- DeleteRoute(route)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeleteRouteWithResult removes a route and returns true if the route was
+// removed. If the route did not exist, false is returned without an error.
+func DeleteRouteWithResult(route Route) (bool, error) {
+	if err := deleteRoute(defaultHandle, route); err != nil {
+		if isNotFound(err) {
+			route.getLogger().Debug("Route to delete does not exist")
+			return false, nil
+		}
+
+		route.getLogger().WithError(err).Error("Unable to delete route")
+		return false, err
+	}
+
+	route.getLogger().Info("Deleted route")
+
+	return true, nil
+}
+
+// newNetnsHandle returns a netlink handle operating in the network namespace
+// at netnsPath. The handle must be released with Delete() by the caller.
+func newNetnsHandle(netnsPath string) (*netlink.Handle, error) {
+	ns, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open netns %s: %s", netnsPath, err)
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create netlink handle in netns %s: %s", netnsPath, err)
+	}
+
+	return h, nil
+}
+
+// ReplaceRouteInNetns adds or replaces the specified route in the network
+// namespace at netnsPath if necessary
+func ReplaceRouteInNetns(route Route, netnsPath string) error {
+	scopedLog := route.getLogger().WithField("netns", netnsPath)
+
+	h, err := newNetnsHandle(netnsPath)
+	if err != nil {
+		scopedLog.WithError(err).Error("Unable to add route")
+		return err
+	}
+	defer h.Delete()
+
+	replaced, err := replaceRoute(h, route)
+	if err != nil {
+		scopedLog.WithError(err).Error("Unable to add route")
+		return fmt.Errorf("unable to add route in netns %s: %s", netnsPath, err)
+	} else if replaced {
+		scopedLog.Info("Updated route")
+	}
+
+	return nil
+}
+
+// DeleteRouteInNetns removes a route from the network namespace at netnsPath.
+// Deleting a route which does not exist is not considered an error.
+func DeleteRouteInNetns(route Route, netnsPath string) error {
+	scopedLog := route.getLogger().WithField("netns", netnsPath)
+
+	h, err := newNetnsHandle(netnsPath)
+	if err != nil {
+		scopedLog.WithError(err).Error("Unable to delete route")
+		return err
+	}
+	defer h.Delete()
+
+	if err := deleteRoute(h, route); err != nil {
+		if isNotFound(err) {
+			scopedLog.Debug("Route to delete does not exist")
+			return nil
+		}
+
+		scopedLog.WithError(err).Error("Unable to delete route")
+		return fmt.Errorf("unable to delete route in netns %s: %s", netnsPath, err)
+	}
+
+	scopedLog.Info("Deleted route")
+
 	return nil
 }
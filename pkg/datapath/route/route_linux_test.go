@@ -17,29 +17,32 @@
 package route
 
 import (
+	"context"
 	"net"
+	"os/exec"
+	"time"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/vishvananda/netlink"
 )
 
-func testReplaceNexthopRoute(c *C, link netlink.Link, routerNet *net.IPNet) {
+func testReplaceNexthopRoute(c *C, link netlink.Link, routerNet *net.IPNet, table int, scope netlink.Scope) {
 	// delete route in case it exists from a previous failed run
-	deleteNexthopRoute(link, routerNet)
+	deleteNexthopRoute(defaultHandle, link, routerNet, table, scope)
 
 	// defer cleanup in case of failure
-	defer deleteNexthopRoute(link, routerNet)
+	defer deleteNexthopRoute(defaultHandle, link, routerNet, table, scope)
 
-	replaced, err := replaceNexthopRoute(link, routerNet)
+	replaced, err := replaceNexthopRoute(defaultHandle, link, routerNet, table, scope, nil)
 	c.Assert(err, IsNil)
 	c.Assert(replaced, Equals, true)
 
-	replaced, err = replaceNexthopRoute(link, routerNet)
+	replaced, err = replaceNexthopRoute(defaultHandle, link, routerNet, table, scope, nil)
 	c.Assert(err, IsNil)
 	c.Assert(replaced, Equals, false)
 
-	err = deleteNexthopRoute(link, routerNet)
+	err = deleteNexthopRoute(defaultHandle, link, routerNet, table, scope)
 	c.Assert(err, IsNil)
 }
 
@@ -50,15 +53,18 @@ func (p *RouteSuite) TestReplaceNexthopRoute(c *C) {
 	ip := net.ParseIP("1.2.3.4")
 	c.Assert(ip, Not(IsNil))
 	routerNet := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
-	testReplaceNexthopRoute(c, link, routerNet)
+	testReplaceNexthopRoute(c, link, routerNet, 0, 0)
+	testReplaceNexthopRoute(c, link, routerNet, 200, 0)
+	testReplaceNexthopRoute(c, link, routerNet, 0, netlink.SCOPE_HOST)
 
 	ip = net.ParseIP("f00d::a02:100:0:815b")
 	c.Assert(ip, Not(IsNil))
 	routerNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
-	testReplaceNexthopRoute(c, link, routerNet)
+	testReplaceNexthopRoute(c, link, routerNet, 0, 0)
+	testReplaceNexthopRoute(c, link, routerNet, 200, 0)
 }
 
-func testReplaceRoute(c *C, prefixStr, nexthopStr string) {
+func testReplaceRoute(c *C, prefixStr, nexthopStr string, table int) {
 	_, prefix, err := net.ParseCIDR(prefixStr)
 	c.Assert(err, IsNil)
 	c.Assert(prefix, Not(IsNil))
@@ -70,6 +76,7 @@ func testReplaceRoute(c *C, prefixStr, nexthopStr string) {
 		Device:  "lo",
 		Prefix:  *prefix,
 		Nexthop: &nexthop,
+		Table:   table,
 	}
 
 	// delete route in case it exists from a previous failed run
@@ -81,16 +88,1368 @@ func testReplaceRoute(c *C, prefixStr, nexthopStr string) {
 		Device: "lo",
 		Prefix: *rt.getNexthopAsIPNet(),
 		Scope:  netlink.SCOPE_LINK,
+		Table:  table,
 	})
 
 	err = ReplaceRoute(rt)
 	c.Assert(err, IsNil)
 
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+	c.Assert(installed.Prefix.String(), Equals, prefix.String())
+	c.Assert(installed.Nexthop.Equal(nexthop), Equals, true)
+
+	exists, err := RouteExists(rt)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Routes of all routing tables are listed
+	routes, err := ListRoutes(rt.Device, ipFamily(prefix.IP))
+	c.Assert(err, IsNil)
+
+	found := false
+	for _, r := range routes {
+		if r.Prefix.String() == prefix.String() {
+			c.Assert(r.Nexthop, Not(IsNil))
+			c.Assert(r.Nexthop.Equal(nexthop), Equals, true)
+			c.Assert(getTable(r.Table), Equals, getTable(table))
+			found = true
+		}
+	}
+	c.Assert(found, Equals, true)
+
 	err = DeleteRoute(rt)
 	c.Assert(err, IsNil)
+
+	installed, err = LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	exists, err = RouteExists(rt)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
 }
 
 func (p *RouteSuite) TestReplaceRoute(c *C) {
-	testReplaceRoute(c, "2.2.0.0/16", "1.2.3.4")
-	testReplaceRoute(c, "f00d::a02:200:0:0/96", "f00d::a02:100:0:815b")
+	testReplaceRoute(c, "2.2.0.0/16", "1.2.3.4", 0)
+	testReplaceRoute(c, "f00d::a02:200:0:0/96", "f00d::a02:100:0:815b", 0)
+	testReplaceRoute(c, "2.2.0.0/16", "1.2.3.4", 200)
+	testReplaceRoute(c, "f00d::a02:200:0:0/96", "f00d::a02:100:0:815b", 200)
+}
+
+func testReplaceRule(c *C, rule Rule) {
+	// delete rule in case it exists from a previous failed run
+	DeleteRule(rule)
+
+	// defer cleanup in case of failure
+	defer DeleteRule(rule)
+
+	added, err := replaceRule(rule)
+	c.Assert(err, IsNil)
+	c.Assert(added, Equals, true)
+
+	added, err = replaceRule(rule)
+	c.Assert(err, IsNil)
+	c.Assert(added, Equals, false)
+
+	err = DeleteRule(rule)
+	c.Assert(err, IsNil)
+
+	// Deleting a rule which does not exist is not an error
+	err = DeleteRule(rule)
+	c.Assert(err, IsNil)
+}
+
+func (p *RouteSuite) TestReplaceRule(c *C) {
+	testReplaceRule(c, Rule{Priority: 100, Mark: 0x200, Mask: 0xf00, Table: 200})
+
+	_, from, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+	testReplaceRule(c, Rule{Priority: 100, Table: 200, From: from})
+}
+
+func (p *RouteSuite) TestListRoutesByProto(c *C) {
+	_, prefix, err := net.ParseCIDR("2.3.0.0/16")
+	c.Assert(err, IsNil)
+
+	nexthop := net.ParseIP("1.2.3.4")
+	c.Assert(nexthop, Not(IsNil))
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: &nexthop,
+		Proto:   200,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+	})
+
+	err = ReplaceRoute(rt)
+	c.Assert(err, IsNil)
+
+	routes, err := ListRoutesByProto(200)
+	c.Assert(err, IsNil)
+	c.Assert(len(routes), Equals, 1)
+	c.Assert(routes[0].Prefix.String(), Equals, prefix.String())
+	c.Assert(routes[0].Device, Equals, "lo")
+	c.Assert(routes[0].Proto, Equals, 200)
+	c.Assert(routes[0].Nexthop.Equal(nexthop), Equals, true)
+}
+
+func (p *RouteSuite) TestReplaceSpecialRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.4.0.0/16")
+	c.Assert(err, IsNil)
+
+	for routeType := range specialTypeNames {
+		rt := Route{
+			Prefix: *prefix,
+			Type:   routeType,
+		}
+
+		// delete route in case it exists from a previous failed run
+		DeleteRoute(rt)
+
+		replaced, err := replaceRoute(defaultHandle, rt)
+		c.Assert(err, IsNil)
+		c.Assert(replaced, Equals, true)
+
+		replaced, err = replaceRoute(defaultHandle, rt)
+		c.Assert(err, IsNil)
+		c.Assert(replaced, Equals, false)
+
+		installed, err := LookupRoute(rt)
+		c.Assert(err, IsNil)
+		c.Assert(installed, Not(IsNil))
+		c.Assert(installed.Type, Equals, routeType)
+
+		err = DeleteRoute(rt)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (p *RouteSuite) TestReplaceAddressTypeRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.30.0.1/32")
+	c.Assert(err, IsNil)
+
+	for routeType := range addressTypeNames {
+		rt := Route{
+			Device: "lo",
+			Prefix: *prefix,
+			Type:   routeType,
+			Table:  200,
+		}
+
+		// delete route in case it exists from a previous failed run
+		DeleteRoute(rt)
+
+		// defer cleanup in case of failure
+		defer DeleteRoute(rt)
+
+		replaced, err := replaceRoute(defaultHandle, rt)
+		c.Assert(err, IsNil)
+		c.Assert(replaced, Equals, true)
+
+		replaced, err = replaceRoute(defaultHandle, rt)
+		c.Assert(err, IsNil)
+		c.Assert(replaced, Equals, false)
+
+		installed, err := LookupRoute(rt)
+		c.Assert(err, IsNil)
+		c.Assert(installed, Not(IsNil))
+		c.Assert(installed.Type, Equals, routeType)
+
+		err = DeleteRoute(rt)
+		c.Assert(err, IsNil)
+
+		installed, err = LookupRoute(rt)
+		c.Assert(err, IsNil)
+		c.Assert(installed, IsNil)
+	}
+}
+
+func (p *RouteSuite) TestReplaceMultipathRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.5.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device: "lo",
+		Prefix: *prefix,
+		Nexthops: []NexthopInfo{
+			{IP: net.ParseIP("1.2.3.5")},
+			{IP: net.ParseIP("1.2.3.6"), Weight: 2},
+		},
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// Defer deletion of route and nexthop routes to cleanup in case of failure
+	defer DeleteRoute(rt)
+	for _, nh := range rt.Nexthops {
+		defer DeleteRoute(Route{
+			Device: "lo",
+			Prefix: *ipAsIPNet(nh.IP),
+			Scope:  netlink.SCOPE_LINK,
+		})
+	}
+
+	replaced, err := replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+
+	// A change of the weight must replace the route
+	rt.Nexthops[1].Weight = 3
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+	c.Assert(len(installed.Nexthops), Equals, 2)
+	c.Assert(installed.Nexthops[0].Weight, Equals, 1)
+	c.Assert(installed.Nexthops[1].Weight, Equals, 3)
+
+	err = DeleteRoute(rt)
+	c.Assert(err, IsNil)
+}
+
+func (p *RouteSuite) TestReplaceOnlinkRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.6.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.7"),
+		Onlink:  true,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	replaced, err := replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+
+	// no L2 nexthop route must have been installed
+	nexthopRoute, err := LookupRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(nexthopRoute, IsNil)
+
+	err = DeleteRoute(rt)
+	c.Assert(err, IsNil)
+}
+
+func (p *RouteSuite) TestReplaceRouteInNetns(c *C) {
+	netnsName := "cilium-route-test"
+	netnsPath := "/var/run/netns/" + netnsName
+
+	// delete netns in case it exists from a previous failed run
+	exec.Command("ip", "netns", "del", netnsName).Run()
+
+	err := exec.Command("ip", "netns", "add", netnsName).Run()
+	c.Assert(err, IsNil)
+	defer exec.Command("ip", "netns", "del", netnsName).Run()
+
+	err = exec.Command("ip", "netns", "exec", netnsName, "ip", "link", "set", "lo", "up").Run()
+	c.Assert(err, IsNil)
+
+	_, prefix, err := net.ParseCIDR("2.7.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.8"),
+	}
+
+	err = ReplaceRouteInNetns(rt, netnsPath)
+	c.Assert(err, IsNil)
+
+	// the route must not have been installed in the current netns
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	err = DeleteRouteInNetns(rt, netnsPath)
+	c.Assert(err, IsNil)
+
+	// Deleting a route which does not exist is not an error
+	err = DeleteRouteInNetns(rt, netnsPath)
+	c.Assert(err, IsNil)
+
+	err = DeleteRouteInNetns(rt, "/var/run/netns/cilium-route-nonexistent")
+	c.Assert(err, Not(IsNil))
+}
+
+func (p *RouteSuite) TestDeleteRouteWithResult(c *C) {
+	_, prefix, err := net.ParseCIDR("2.8.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.9"),
+		Onlink:  true,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	err = ReplaceRoute(rt)
+	c.Assert(err, IsNil)
+
+	deleted, err := DeleteRouteWithResult(rt)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, true)
+
+	deleted, err = DeleteRouteWithResult(rt)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, false)
+}
+
+func (p *RouteSuite) TestReplaceRoutes(c *C) {
+	_, prefix1, err := net.ParseCIDR("2.9.0.0/16")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("2.9.1.0/24")
+	c.Assert(err, IsNil)
+
+	routes := []Route{
+		{Device: "lo", Prefix: *prefix1, Nexthop: parseIP("1.2.3.10"), Onlink: true},
+		{Device: "lo", Prefix: *prefix2, Nexthop: parseIP("1.2.3.10"), Onlink: true},
+	}
+
+	for _, rt := range routes {
+		// delete route in case it exists from a previous failed run
+		DeleteRoute(rt)
+
+		// defer cleanup in case of failure
+		defer DeleteRoute(rt)
+	}
+
+	err = ReplaceRoutes(routes)
+	c.Assert(err, IsNil)
+
+	installed, err := LookupRoutes(routes)
+	c.Assert(err, IsNil)
+	c.Assert(installed, HasLen, len(routes))
+	for i, rt := range installed {
+		c.Assert(rt, Not(IsNil))
+		c.Assert(rt.Prefix.String(), Equals, routes[i].Prefix.String())
+	}
+
+	// Installed routes are skipped
+	err = ReplaceRoutes(routes)
+	c.Assert(err, IsNil)
+
+	for _, rt := range routes {
+		err = DeleteRoute(rt)
+		c.Assert(err, IsNil)
+	}
+
+	installed, err = LookupRoutes(routes)
+	c.Assert(err, IsNil)
+	c.Assert(installed, DeepEquals, []*Route{nil, nil})
+
+	// The route with the invalid device is installed last and must cause
+	// all other routes to be rolled back
+	invalid := append(routes, Route{
+		Device:  "nonexistent-dev",
+		Prefix:  net.IPNet{IP: net.ParseIP("2.9.0.0"), Mask: net.CIDRMask(15, 32)},
+		Nexthop: parseIP("1.2.3.10"),
+	})
+	err = ReplaceRoutes(invalid)
+	c.Assert(err, Not(IsNil))
+
+	for _, rt := range routes {
+		installed, err := LookupRoute(rt)
+		c.Assert(err, IsNil)
+		c.Assert(installed, IsNil)
+	}
+
+	// A route which replaced an installed route is restored to the
+	// previous route instead of being removed
+	previous := Route{Device: "lo", Prefix: *prefix1, Nexthop: parseIP("1.2.3.11"), Onlink: true}
+	err = ReplaceRoute(previous)
+	c.Assert(err, IsNil)
+	defer DeleteRoute(previous)
+
+	err = ReplaceRoutes(invalid)
+	c.Assert(err, Not(IsNil))
+
+	installed, err = LookupRoutes([]Route{previous, routes[0], routes[1]})
+	c.Assert(err, IsNil)
+	c.Assert(installed[0], Not(IsNil))
+	c.Assert(installed[1], IsNil)
+	c.Assert(installed[2], IsNil)
+}
+
+func (p *RouteSuite) TestSubscribeRouteChanges(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	routes, err := SubscribeRouteChanges(ctx, "lo")
+	c.Assert(err, IsNil)
+
+	_, prefix, err := net.ParseCIDR("2.10.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.11"),
+		Onlink:  true,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	err = ReplaceRoute(rt)
+	c.Assert(err, IsNil)
+
+	found := false
+	timeout := time.After(5 * time.Second)
+	for !found {
+		select {
+		case r := <-routes:
+			found = r.Prefix.String() == prefix.String()
+		case <-timeout:
+			c.Fatalf("timeout while waiting for route update")
+		}
+	}
+
+	cancel()
+
+	// the channel must be closed after cancellation
+	for range routes {
+	}
+}
+
+func (p *RouteSuite) TestReplaceRouteLinkIndex(c *C) {
+	link, err := netlink.LinkByName("lo")
+	c.Assert(err, IsNil)
+
+	_, prefix, err := net.ParseCIDR("2.11.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		LinkIndex: link.Attrs().Index,
+		Prefix:    *prefix,
+		Nexthop:   parseIP("1.2.3.12"),
+		Onlink:    true,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	err = ReplaceRoute(rt)
+	c.Assert(err, IsNil)
+
+	installed, err := LookupRoute(Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: rt.Nexthop,
+		Onlink:  true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+
+	err = DeleteRoute(rt)
+	c.Assert(err, IsNil)
+
+	rt.LinkIndex = 1 << 20
+	err = ReplaceRoute(rt)
+	c.Assert(err, Not(IsNil))
+}
+
+func (p *RouteSuite) TestReplaceRouteDryRun(c *C) {
+	_, prefix, err := net.ParseCIDR("2.12.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.13"),
+		Table:   200,
+	}
+
+	commands, err := ReplaceRouteDryRun(rt)
+	c.Assert(err, IsNil)
+	c.Assert(commands, DeepEquals, []string{
+		"ip route add 1.2.3.13/32 table 200 scope link dev lo",
+		"ip route add 2.12.0.0/16 via 1.2.3.13 table 200 dev lo",
+	})
+
+	// no route must have been installed
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	// An explicit MTU is used as is
+	commands, err = ReplaceRouteDryRun(Route{
+		Prefix:      *prefix,
+		Device:      "lo",
+		MTU:         9000,
+		MTUExplicit: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(commands, DeepEquals, []string{"ip route add 2.12.0.0/16 mtu 9000 dev lo"})
+}
+
+func (p *RouteSuite) TestReplaceRouteExplicitMTU(c *C) {
+	_, prefix, err := net.ParseCIDR("2.34.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:      "lo",
+		Prefix:      *prefix,
+		Nexthop:     parseIP("1.2.3.34"),
+		Onlink:      true,
+		MTU:         1400,
+		MTUExplicit: true,
+	}
+
+	// Defer deletion of route to cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	replaced, err := replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+
+	// A change of the explicit MTU replaces the installed route
+	rt.MTU = 1300
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+	c.Assert(installed.MTU, Equals, 1300)
+}
+
+func (p *RouteSuite) TestFromNetlinkRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.13.0.0/16")
+	c.Assert(err, IsNil)
+
+	link, err := netlink.LinkByName("lo")
+	c.Assert(err, IsNil)
+
+	nr := netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       prefix,
+		Gw:        net.ParseIP("1.2.3.14"),
+		Table:     200,
+		Priority:  10,
+	}
+
+	rt, err := FromNetlinkRoute(nr)
+	c.Assert(err, IsNil)
+	c.Assert(rt.Device, Equals, "lo")
+	c.Assert(rt.LinkIndex, Equals, link.Attrs().Index)
+	c.Assert(rt.Prefix.String(), Equals, "2.13.0.0/16")
+	c.Assert(rt.Nexthop.String(), Equals, "1.2.3.14")
+	c.Assert(rt.Table, Equals, 200)
+	c.Assert(rt.Priority, Equals, 10)
+
+	nr.LinkIndex = 1 << 20
+	rt, err = FromNetlinkRoute(nr)
+	c.Assert(err, Equals, ErrLinkNotFound)
+	c.Assert(rt.Device, Equals, "if1048576")
+	c.Assert(rt.Prefix.String(), Equals, "2.13.0.0/16")
+}
+
+func (p *RouteSuite) TestDeleteRoutesForDevice(c *C) {
+	_, prefix1, err := net.ParseCIDR("2.14.0.0/16")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("2.15.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt1 := Route{
+		Device:  "lo",
+		Prefix:  *prefix1,
+		Nexthop: parseIP("1.2.3.15"),
+		Table:   200,
+		Proto:   201,
+	}
+	rt2 := Route{
+		Device:  "lo",
+		Prefix:  *prefix2,
+		Nexthop: parseIP("1.2.3.15"),
+		Table:   200,
+		Proto:   202,
+	}
+
+	// Defer deletion of routes and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt1)
+	defer DeleteRoute(rt2)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt1.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+		Table:  200,
+	})
+
+	c.Assert(ReplaceRoute(rt1), IsNil)
+	c.Assert(ReplaceRoute(rt2), IsNil)
+
+	err = DeleteRoutesForDevice("lo", 201)
+	c.Assert(err, IsNil)
+
+	installed, err := LookupRoute(rt1)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	installed, err = LookupRoute(rt2)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+
+	err = DeleteRoutesForDevice("not-existing", 201)
+	c.Assert(err, Not(IsNil))
+}
+
+func (p *RouteSuite) TestFlushRoutes(c *C) {
+	_, prefix1, err := net.ParseCIDR("2.24.0.0/16")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("2.25.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt1 := Route{
+		Device:  "lo",
+		Prefix:  *prefix1,
+		Nexthop: parseIP("1.2.3.24"),
+		Table:   200,
+		Proto:   201,
+	}
+	rt2 := Route{
+		Device:  "lo",
+		Prefix:  *prefix2,
+		Nexthop: parseIP("1.2.3.24"),
+		Proto:   202,
+	}
+
+	// Defer deletion of routes and nexthop routes to cleanup in case of
+	// failure
+	defer DeleteRoute(rt1)
+	defer DeleteRoute(rt2)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt1.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+		Table:  200,
+	})
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt2.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+	})
+
+	c.Assert(ReplaceRoute(rt1), IsNil)
+	c.Assert(ReplaceRoute(rt2), IsNil)
+
+	err = FlushRoutes("lo", netlink.FAMILY_V4)
+	c.Assert(err, IsNil)
+
+	installed, err := LookupRoute(rt1)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	installed, err = LookupRoute(rt2)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	err = FlushRoutes("not-existing", netlink.FAMILY_V4)
+	c.Assert(err, Not(IsNil))
+}
+
+func (p *RouteSuite) TestRefreshCache(c *C) {
+	_, prefix, err := net.ParseCIDR("2.16.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.16"),
+	}
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+	})
+	defer installedRoutes.set("lo", nil)
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+	c.Assert(installedRoutes.contains(&rt), Equals, false)
+
+	c.Assert(RefreshCache("lo"), IsNil)
+	c.Assert(installedRoutes.contains(&rt), Equals, true)
+
+	replaced, err := replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+
+	// A cached route does not hide a missing L2 nexthop route
+	link, err := netlink.LinkByName("lo")
+	c.Assert(err, IsNil)
+	nexthopRoute := createNexthopRoute(link, rt.getNexthopAsIPNet(), 0, 0)
+	c.Assert(netlink.RouteDel(nexthopRoute), IsNil)
+	c.Assert(hasNexthop(defaultHandle, &rt), Equals, false)
+	c.Assert(ReplaceRoute(rt), IsNil)
+	c.Assert(lookup(defaultHandle, link, nexthopRoute), Not(IsNil))
+
+	c.Assert(DeleteRoute(rt), IsNil)
+	c.Assert(installedRoutes.contains(&rt), Equals, false)
+}
+
+func (p *RouteSuite) TestDeleteScopedIPv6Route(c *C) {
+	_, prefix, err := net.ParseCIDR("f00d::a02:1100:0:0/96")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device: "lo",
+		Prefix: *prefix,
+		Scope:  netlink.SCOPE_LINK,
+	}
+
+	// Defer deletion of route to cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+
+	c.Assert(DeleteRoute(rt), IsNil)
+
+	routes, err := ListRoutes("lo", netlink.FAMILY_V6)
+	c.Assert(err, IsNil)
+	for _, r := range routes {
+		c.Assert(r.Prefix.String(), Not(Equals), prefix.String())
+	}
+}
+
+func (p *RouteSuite) TestReplaceDirectRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.33.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device: "lo",
+		Prefix: *prefix,
+	}
+
+	// Defer deletion of route to cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	// A directly attached route has no nexthop and is installed without
+	// an L2 nexthop route
+	c.Assert(ReplaceRoute(rt), IsNil)
+
+	routes, err := ListRoutes("lo", netlink.FAMILY_V4)
+	c.Assert(err, IsNil)
+	found := 0
+	for _, r := range routes {
+		if r.Prefix.String() == prefix.String() {
+			c.Assert(r.Nexthop, IsNil)
+			found++
+		}
+	}
+	c.Assert(found, Equals, 1)
+
+	c.Assert(DeleteRoute(rt), IsNil)
+}
+
+func (p *RouteSuite) TestReplaceRouteWithPrevious(c *C) {
+	_, prefix, err := net.ParseCIDR("2.17.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.17"),
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// Defer deletion of route and nexthop routes to cleanup in case of failure
+	defer DeleteRoute(rt)
+	for _, nexthop := range []string{"1.2.3.17", "1.2.3.18"} {
+		defer DeleteRoute(Route{
+			Device: "lo",
+			Prefix: *ipAsIPNet(net.ParseIP(nexthop)),
+			Scope:  netlink.SCOPE_LINK,
+		})
+	}
+
+	previous, replaced, err := ReplaceRouteWithPrevious(rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+	c.Assert(previous, IsNil)
+
+	previous, replaced, err = ReplaceRouteWithPrevious(rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+	c.Assert(previous, Not(IsNil))
+	c.Assert(previous.Nexthop.String(), Equals, "1.2.3.17")
+
+	rt.Nexthop = parseIP("1.2.3.18")
+	previous, replaced, err = ReplaceRouteWithPrevious(rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+	c.Assert(previous, Not(IsNil))
+	c.Assert(previous.Device, Equals, "lo")
+	c.Assert(previous.Nexthop.String(), Equals, "1.2.3.17")
+}
+
+func (p *RouteSuite) TestFindOverlapping(c *C) {
+	_, prefix, err := net.ParseCIDR("2.18.0.0/16")
+	c.Assert(err, IsNil)
+	_, subnet, err := net.ParseCIDR("2.18.1.0/24")
+	c.Assert(err, IsNil)
+	_, other, err := net.ParseCIDR("2.19.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.19"),
+		Table:   200,
+	}
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+		Table:  200,
+	})
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+
+	routes, err := FindOverlapping(Route{Device: "lo", Prefix: *subnet, Table: 200})
+	c.Assert(err, IsNil)
+	c.Assert(len(routes), Equals, 1)
+	c.Assert(routes[0].Prefix.String(), Equals, prefix.String())
+	c.Assert(routes[0].Table, Equals, 200)
+
+	routes, err = FindOverlapping(Route{Device: "lo", Prefix: *other, Table: 200})
+	c.Assert(err, IsNil)
+	c.Assert(len(routes), Equals, 0)
+}
+
+func (p *RouteSuite) TestListAllRoutes(c *C) {
+	_, prefix, err := net.ParseCIDR("2.20.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.20"),
+		Table:   201,
+	}
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+		Table:  201,
+	})
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+
+	routes, err := ListAllRoutes(netlink.FAMILY_V4)
+	c.Assert(err, IsNil)
+
+	found := false
+	for _, r := range routes {
+		if r.Prefix.String() == prefix.String() {
+			c.Assert(r.Table, Equals, 201)
+			c.Assert(r.Device, Equals, "lo")
+			found = true
+		}
+	}
+	c.Assert(found, Equals, true)
+}
+
+func (p *RouteSuite) TestReplaceGatewayOnlyRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.21.0.0/16")
+	c.Assert(err, IsNil)
+
+	link, err := netlink.LinkByName("lo")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.21"),
+	}
+
+	// The nexthop must be reachable for the kernel to resolve the device
+	routerNet := rt.getNexthopAsIPNet()
+	defer deleteNexthopRoute(defaultHandle, link, routerNet, 0, 0)
+	_, err = replaceNexthopRoute(defaultHandle, link, routerNet, 0, 0, nil)
+	c.Assert(err, IsNil)
+
+	// Defer deletion of route to cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	replaced, err := replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+	c.Assert(installed.Device, Equals, "lo")
+
+	c.Assert(DeleteRoute(rt), IsNil)
+
+	installed, err = LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+}
+
+func (p *RouteSuite) TestResolveRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("2.22.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.22"),
+	}
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+	})
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+
+	resolved, err := ResolveRoute(net.ParseIP("2.22.1.1"))
+	c.Assert(err, IsNil)
+	c.Assert(resolved, Not(IsNil))
+	c.Assert(resolved.Device, Equals, "lo")
+	c.Assert(resolved.Nexthop.String(), Equals, "1.2.3.22")
+}
+
+func (p *RouteSuite) TestReplaceRouteNexthopMAC(c *C) {
+	_, prefix, err := net.ParseCIDR("2.23.0.0/16")
+	c.Assert(err, IsNil)
+	mac, err := net.ParseMAC("02:00:00:00:00:23")
+	c.Assert(err, IsNil)
+
+	// The kernel keys neighbor entries of loopback devices on the
+	// unspecified address, a device with link layer addresses is required
+	dummy := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "cilium-test-mac"}}
+
+	// delete link in case it exists from a previous failed run
+	netlink.LinkDel(dummy)
+
+	c.Assert(netlink.LinkAdd(dummy), IsNil)
+	// Deleting the link removes the route, nexthop route and neighbor
+	defer netlink.LinkDel(dummy)
+
+	link, err := netlink.LinkByName(dummy.Name)
+	c.Assert(err, IsNil)
+	c.Assert(netlink.LinkSetARPOn(link), IsNil)
+	c.Assert(netlink.LinkSetUp(link), IsNil)
+
+	rt := Route{
+		Device:     dummy.Name,
+		Prefix:     *prefix,
+		Nexthop:    parseIP("1.2.3.23"),
+		NexthopMAC: mac,
+	}
+	neighbor := Neighbor{IP: *rt.Nexthop, MAC: mac, Device: dummy.Name}
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+	c.Assert(lookupNeighbor(defaultHandle, neighbor.getNetlinkNeigh(link)), Not(IsNil))
+
+	added, err := replaceNeighbor(defaultHandle, link, neighbor)
+	c.Assert(err, IsNil)
+	c.Assert(added, Equals, false)
+
+	c.Assert(DeleteNeighbor(neighbor), IsNil)
+	c.Assert(lookupNeighbor(defaultHandle, neighbor.getNetlinkNeigh(link)), IsNil)
+
+	c.Assert(ReplaceNeighbor(neighbor), IsNil)
+	c.Assert(lookupNeighbor(defaultHandle, neighbor.getNetlinkNeigh(link)), Not(IsNil))
+
+	// A nexthop MAC is rejected for loopback devices
+	rt.Device = "lo"
+	c.Assert(ReplaceRoute(rt), Not(IsNil))
+}
+
+func (p *RouteSuite) TestRouteManager(c *C) {
+	_, prefix, err := net.ParseCIDR("2.26.0.0/16")
+	c.Assert(err, IsNil)
+
+	m, err := NewRouteManager()
+	c.Assert(err, IsNil)
+	defer m.Close()
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.26"),
+	}
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+	})
+
+	c.Assert(m.ReplaceRoute(rt), IsNil)
+
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+
+	routes, err := m.ListRoutes("lo", netlink.FAMILY_V4)
+	c.Assert(err, IsNil)
+	toAdd, _ := DiffRoutes([]Route{rt}, routes)
+	c.Assert(toAdd, HasLen, 0)
+
+	c.Assert(m.DeleteRoute(rt), IsNil)
+
+	installed, err = LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	// Deleting a route which does not exist is not an error
+	c.Assert(m.DeleteRoute(rt), IsNil)
+}
+
+// benchmarkRoutes returns n routes via the same nexthop on the loopback device
+func benchmarkRoutes(n int) []Route {
+	routes := make([]Route, 0, n)
+	for i := 0; i < n; i++ {
+		routes = append(routes, Route{
+			Device:  "lo",
+			Prefix:  net.IPNet{IP: net.IPv4(10, 200, byte(i>>8), byte(i)), Mask: net.CIDRMask(32, 32)},
+			Nexthop: parseIP("1.2.3.27"),
+		})
+	}
+
+	return routes
+}
+
+func cleanupBenchmarkRoutes(routes []Route) {
+	for _, rt := range routes {
+		DeleteRoute(rt)
+	}
+	DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *ipAsIPNet(net.ParseIP("1.2.3.27")),
+		Scope:  netlink.SCOPE_LINK,
+	})
+}
+
+func (p *RouteSuite) BenchmarkReplaceRoute(c *C) {
+	routes := benchmarkRoutes(c.N)
+	defer cleanupBenchmarkRoutes(routes)
+
+	c.ResetTimer()
+	for _, rt := range routes {
+		c.Assert(ReplaceRoute(rt), IsNil)
+	}
+}
+
+func (p *RouteSuite) BenchmarkRouteManagerReplaceRoute(c *C) {
+	routes := benchmarkRoutes(c.N)
+	defer cleanupBenchmarkRoutes(routes)
+
+	m, err := NewRouteManager()
+	c.Assert(err, IsNil)
+	defer m.Close()
+
+	c.ResetTimer()
+	for _, rt := range routes {
+		c.Assert(m.ReplaceRoute(rt), IsNil)
+	}
+}
+
+func (p *RouteSuite) TestDeleteRouteByPrefix(c *C) {
+	_, prefix, err := net.ParseCIDR("2.28.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.28"),
+		Table:   200,
+	}
+
+	// Defer deletion of route and nexthop route to cleanup in case of failure
+	defer DeleteRoute(rt)
+	defer DeleteRoute(Route{
+		Device: "lo",
+		Prefix: *rt.getNexthopAsIPNet(),
+		Scope:  netlink.SCOPE_LINK,
+		Table:  200,
+	})
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+
+	// The route is not installed in the main table
+	c.Assert(DeleteRouteByPrefix(*prefix, "lo", 0), IsNil)
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+
+	c.Assert(DeleteRouteByPrefix(*prefix, "lo", 200), IsNil)
+	installed, err = LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, IsNil)
+
+	// Deleting a route which does not exist is not an error
+	c.Assert(DeleteRouteByPrefix(*prefix, "lo", 200), IsNil)
+	c.Assert(DeleteRouteByPrefix(*prefix, "nonexistent-dev", 200), Not(IsNil))
+}
+
+func (p *RouteSuite) TestCleanupRoutes(c *C) {
+	_, prefix, err := net.ParseCIDR("2.29.0.0/16")
+	c.Assert(err, IsNil)
+	_, other, err := net.ParseCIDR("2.29.1.0/24")
+	c.Assert(err, IsNil)
+
+	routes := []Route{
+		{Device: "lo", Prefix: *prefix, Nexthop: parseIP("1.2.3.29"), Onlink: true, Proto: 201},
+		{Device: "lo", Prefix: *prefix, Nexthop: parseIP("1.2.3.29"), Onlink: true, Proto: 201, Table: 200},
+	}
+	foreign := Route{Device: "lo", Prefix: *other, Nexthop: parseIP("1.2.3.29"), Onlink: true, Proto: 202}
+
+	// Defer deletion of routes to cleanup in case of failure
+	for _, rt := range append(routes, foreign) {
+		defer DeleteRoute(rt)
+		c.Assert(ReplaceRoute(rt), IsNil)
+	}
+
+	_, err = CleanupRoutes(0)
+	c.Assert(err, Not(IsNil))
+
+	deleted, err := CleanupRoutes(201)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, len(routes))
+
+	for _, rt := range routes {
+		installed, err := LookupRoute(rt)
+		c.Assert(err, IsNil)
+		c.Assert(installed, IsNil)
+	}
+
+	// Routes of other protocols are never removed
+	installed, err := LookupRoute(foreign)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+
+	deleted, err = CleanupRoutes(201)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 0)
+}
+
+func (p *RouteSuite) TestReplaceRouteStrictSrc(c *C) {
+	_, prefix, err := net.ParseCIDR("2.31.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:    "lo",
+		Prefix:    *prefix,
+		Local:     net.ParseIP("127.0.0.1"),
+		StrictSrc: true,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	replaced, err := replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, false)
+
+	// A change of the preferred source address replaces the route
+	rt.Local = net.ParseIP("127.0.0.2")
+	exists, err := RouteExists(rt)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	replaced, err = replaceRoute(defaultHandle, rt)
+	c.Assert(err, IsNil)
+	c.Assert(replaced, Equals, true)
+
+	installed, err := LookupRoute(rt)
+	c.Assert(err, IsNil)
+	c.Assert(installed, Not(IsNil))
+	c.Assert(installed.Local.Equal(rt.Local), Equals, true)
+
+	c.Assert(DeleteRoute(rt), IsNil)
+}
+
+func (p *RouteSuite) TestRouteWatcher(c *C) {
+	w, err := NewRouteWatcher("lo")
+	c.Assert(err, IsNil)
+
+	_, prefix, err := net.ParseCIDR("2.32.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.32"),
+		Onlink:  true,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	waitForEvent := func(deleted bool) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-w.Events():
+				if event.Route.Prefix.String() == prefix.String() && event.Deleted == deleted {
+					return
+				}
+			case <-timeout:
+				c.Fatalf("timeout while waiting for route event")
+			}
+		}
+	}
+
+	c.Assert(ReplaceRoute(rt), IsNil)
+	waitForEvent(false)
+
+	c.Assert(DeleteRoute(rt), IsNil)
+	waitForEvent(true)
+
+	w.Close()
+
+	// the channel must be closed after the watcher has been stopped
+	for range w.Events() {
+	}
+}
+
+func (p *RouteSuite) TestRouteWatcherResyncListing(c *C) {
+	_, prefix, err := net.ParseCIDR("2.35.0.0/16")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "lo",
+		Prefix:  *prefix,
+		Nexthop: parseIP("1.2.3.35"),
+		Onlink:  true,
+		Table:   200,
+	}
+
+	// delete route in case it exists from a previous failed run
+	DeleteRoute(rt)
+
+	// defer cleanup in case of failure
+	defer DeleteRoute(rt)
+
+	w := &RouteWatcher{device: "lo", known: map[string]Route{}}
+	findEvent := func(deleted bool) bool {
+		routes, ok := w.listRoutes(context.Background())
+		c.Assert(ok, Equals, true)
+
+		for _, event := range w.resync(routes) {
+			if event.Route.Prefix.String() == prefix.String() &&
+				event.Route.Table == 200 && event.Deleted == deleted {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Routes outside of the main table are picked up by the resync
+	c.Assert(ReplaceRoute(rt), IsNil)
+	c.Assert(findEvent(false), Equals, true)
+
+	c.Assert(DeleteRoute(rt), IsNil)
+	c.Assert(findEvent(true), Equals, true)
+}
+
+func (p *RouteSuite) TestReplaceRouteAsyncConcurrent(c *C) {
+	routes := benchmarkRoutes(32)
+	defer cleanupBenchmarkRoutes(routes)
+
+	// All routes share the same L2 nexthop route
+	results := make([]<-chan error, 0, len(routes))
+	for _, rt := range routes {
+		results = append(results, ReplaceRouteAsync(rt))
+	}
+
+	for _, errChan := range results {
+		c.Assert(<-errChan, IsNil)
+	}
+
+	installed, err := LookupRoutes(routes)
+	c.Assert(err, IsNil)
+	for _, rt := range installed {
+		c.Assert(rt, Not(IsNil))
+	}
 }
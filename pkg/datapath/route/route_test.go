@@ -15,11 +15,18 @@
 package route
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/cilium/cilium/pkg/mtu"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	. "gopkg.in/check.v1"
 )
 
@@ -71,3 +78,1009 @@ func (p *RouteSuite) TestToIPCommand(c *C) {
 		c.Assert(result, DeepEquals, expRes)
 	}
 }
+
+func (p *RouteSuite) TestGetNetlinkRouteTable(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+	}
+	c.Assert(r.getNetlinkRoute().Table, Equals, 0)
+	c.Assert(getTable(r.Table), Equals, unix.RT_TABLE_MAIN)
+
+	r.Table = 200
+	c.Assert(r.getNetlinkRoute().Table, Equals, 200)
+	c.Assert(getTable(r.Table), Equals, 200)
+}
+
+func (p *RouteSuite) TestNewRouteFromNetlink(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	nlRoute := &netlink.Route{
+		Dst:      prefix,
+		Src:      net.ParseIP("10.0.0.1"),
+		Gw:       net.ParseIP("192.168.0.1"),
+		MTU:      1450,
+		Scope:    netlink.SCOPE_LINK,
+		Table:    200,
+		Protocol: 100,
+	}
+	r := newRouteFromNetlink("eth0", nlRoute)
+	c.Assert(r.Prefix.String(), Equals, "10.0.0.0/8")
+	c.Assert(r.Nexthop, Not(IsNil))
+	c.Assert(r.Nexthop.String(), Equals, "192.168.0.1")
+	c.Assert(r.Local.String(), Equals, "10.0.0.1")
+	c.Assert(r.Device, Equals, "eth0")
+	c.Assert(r.MTU, Equals, 1450)
+	c.Assert(r.Scope, Equals, netlink.SCOPE_LINK)
+	c.Assert(r.Table, Equals, 200)
+	c.Assert(r.Proto, Equals, 100)
+	c.Assert(r.getNetlinkRoute().Protocol, Equals, 100)
+
+	// default route without destination
+	nlRoute = &netlink.Route{Gw: net.ParseIP("f00d::1")}
+	r = newRouteFromNetlink("eth0", nlRoute)
+	c.Assert(r.Prefix.String(), Equals, "::/0")
+	c.Assert(r.Nexthop.String(), Equals, "f00d::1")
+}
+
+func (p *RouteSuite) TestToIPCommandPriority(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthop:  parseIP("192.168.0.1"),
+		Priority: 10,
+	}
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 via 192.168.0.1 metric 10 dev eth0")
+	c.Assert(r.getNetlinkRoute().Priority, Equals, 10)
+}
+
+func (p *RouteSuite) TestGetPriority(c *C) {
+	_, v4, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	_, v6, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+
+	c.Assert(getPriority(v4, 0), Equals, 0)
+	c.Assert(getPriority(v4, 10), Equals, 10)
+	c.Assert(getPriority(v6, 0), Equals, ip6DefaultPriority)
+	c.Assert(getPriority(v6, 10), Equals, 10)
+}
+
+func (p *RouteSuite) TestSpecialType(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+	}
+	c.Assert(r.isSpecialType(), Equals, false)
+	c.Assert(getType(r.Type), Equals, unix.RTN_UNICAST)
+
+	for routeType, name := range specialTypeNames {
+		r.Type = routeType
+		c.Assert(r.isSpecialType(), Equals, true)
+		c.Assert(getType(r.Type), Equals, routeType)
+		c.Assert(r.getNetlinkRoute().Type, Equals, routeType)
+
+		result := strings.Join(r.ToIPCommand("eth0"), " ")
+		c.Assert(result, Equals, "ip route add "+name+" 10.0.0.0/8")
+	}
+
+	r.Type = unix.RTN_LOCAL
+	c.Assert(r.isSpecialType(), Equals, false)
+}
+
+func (p *RouteSuite) TestAddressType(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.1"),
+			Mask: net.CIDRMask(32, 32),
+		},
+		Device: "eth0",
+		Type:   unix.RTN_LOCAL,
+		Table:  200,
+	}
+	c.Assert(r.isAddressType(), Equals, true)
+	c.Assert(r.isSpecialType(), Equals, false)
+	c.Assert(r.Validate(), IsNil)
+	c.Assert(r.getNetlinkRoute().Scope, Equals, netlink.SCOPE_HOST)
+
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add local 10.0.0.1/32 table 200 dev eth0")
+	result = strings.Join(r.ToIPDeleteCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route del local 10.0.0.1/32 table 200 dev eth0")
+
+	r.Type = unix.RTN_BROADCAST
+	c.Assert(r.getNetlinkRoute().Scope, Equals, netlink.SCOPE_LINK)
+	r.Scope = netlink.SCOPE_HOST
+	c.Assert(r.getNetlinkRoute().Scope, Equals, netlink.SCOPE_HOST)
+
+	// No L2 nexthop route is installed and a nexthop is rejected
+	r.Nexthop = parseIP("10.0.0.2")
+	c.Assert(r.requiresNexthopRoute(), Equals, false)
+	c.Assert(r.Validate(), Not(IsNil))
+}
+
+func (p *RouteSuite) TestGetNetlinkRouteMultiPath(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthop: parseIP("192.168.0.1"),
+		Nexthops: []NexthopInfo{
+			{IP: net.ParseIP("192.168.0.2")},
+			{IP: net.ParseIP("192.168.0.3"), Weight: 3},
+		},
+	}
+	rt := r.getNetlinkRoute()
+	c.Assert(rt.Gw, IsNil)
+	c.Assert(len(rt.MultiPath), Equals, 2)
+	c.Assert(rt.MultiPath[0].Gw.String(), Equals, "192.168.0.2")
+	c.Assert(rt.MultiPath[0].Hops, Equals, 0)
+	c.Assert(rt.MultiPath[1].Gw.String(), Equals, "192.168.0.3")
+	c.Assert(rt.MultiPath[1].Hops, Equals, 2)
+
+	c.Assert(multiPathEqual(rt.MultiPath, r.getNetlinkRoute().MultiPath), Equals, true)
+	c.Assert(multiPathEqual(rt.MultiPath, rt.MultiPath[:1]), Equals, false)
+	c.Assert(multiPathEqual(nil, nil), Equals, true)
+
+	other := r.getNetlinkRoute()
+	other.MultiPath[1].Hops = 0
+	c.Assert(multiPathEqual(rt.MultiPath, other.MultiPath), Equals, false)
+
+	converted := newRouteFromNetlink("eth0", &rt)
+	c.Assert(converted.Nexthop, IsNil)
+	c.Assert(len(converted.Nexthops), Equals, 2)
+	c.Assert(converted.Nexthops[1].IP.String(), Equals, "192.168.0.3")
+	c.Assert(converted.Nexthops[1].Weight, Equals, 3)
+}
+
+func (p *RouteSuite) TestOnlink(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthop: parseIP("192.168.0.1"),
+		Onlink:  true,
+	}
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 via 192.168.0.1 onlink dev eth0")
+
+	rt := r.getNetlinkRoute()
+	c.Assert(rt.Flags&int(netlink.FLAG_ONLINK), Not(Equals), 0)
+	c.Assert(newRouteFromNetlink("eth0", &rt).Onlink, Equals, true)
+
+	r.Onlink = false
+	rt = r.getNetlinkRoute()
+	c.Assert(rt.Flags&int(netlink.FLAG_ONLINK), Equals, 0)
+	c.Assert(newRouteFromNetlink("eth0", &rt).Onlink, Equals, false)
+}
+
+func (p *RouteSuite) TestRequiresNexthopRoute(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Device:  "eth0",
+		Nexthop: parseIP("192.168.0.1"),
+	}
+	c.Assert(r.requiresNexthopRoute(), Equals, true)
+
+	// Directly attached routes have no nexthop to make reachable
+	direct := r
+	direct.Nexthop = nil
+	c.Assert(direct.requiresNexthopRoute(), Equals, false)
+
+	onlink := r
+	onlink.Onlink = true
+	c.Assert(onlink.requiresNexthopRoute(), Equals, false)
+
+	encap := r
+	encap.Encap = &netlink.MPLSEncap{Labels: []int{100}}
+	c.Assert(encap.requiresNexthopRoute(), Equals, false)
+}
+
+func (p *RouteSuite) TestScopeEqual(c *C) {
+	_, v4, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	_, v6, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+
+	c.Assert(scopeEqual(v4, netlink.SCOPE_LINK, netlink.SCOPE_LINK), Equals, true)
+	c.Assert(scopeEqual(v4, netlink.SCOPE_UNIVERSE, netlink.SCOPE_LINK), Equals, false)
+	c.Assert(scopeEqual(v6, netlink.SCOPE_LINK, netlink.SCOPE_LINK), Equals, true)
+	c.Assert(scopeEqual(v6, netlink.SCOPE_UNIVERSE, netlink.SCOPE_LINK), Equals, true)
+}
+
+func (p *RouteSuite) TestIsNotFound(c *C) {
+	c.Assert(isNotFound(unix.ESRCH), Equals, true)
+	c.Assert(isNotFound(unix.ENOENT), Equals, true)
+	c.Assert(isNotFound(unix.EEXIST), Equals, false)
+	c.Assert(isNotFound(fmt.Errorf("unable to lookup interface")), Equals, false)
+}
+
+func (p *RouteSuite) TestToIPDeleteCommand(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthop: parseIP("192.168.0.1"),
+		MTU:     1450,
+	}
+	result := strings.Join(r.ToIPDeleteCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route del 10.0.0.0/8 dev eth0")
+
+	r.Table = 200
+	r.Priority = 10
+	result = strings.Join(r.ToIPDeleteCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route del 10.0.0.0/8 table 200 metric 10 dev eth0")
+
+	r = Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("f00d::"),
+			Mask: net.CIDRMask(64, 128),
+		},
+		Type: unix.RTN_BLACKHOLE,
+	}
+	result = strings.Join(r.ToIPDeleteCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip -6 route del blackhole f00d::/64")
+}
+
+func (p *RouteSuite) TestToIPCommandTableScope(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthop:  parseIP("192.168.0.1"),
+		Priority: 10,
+		Table:    200,
+		Scope:    netlink.SCOPE_LINK,
+	}
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 via 192.168.0.1 metric 10 table 200 scope link dev eth0")
+
+	result = strings.Join(r.ToIPDeleteCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route del 10.0.0.0/8 table 200 metric 10 scope link dev eth0")
+
+	r.Type = unix.RTN_BLACKHOLE
+	r.Nexthop = nil
+	r.Scope = 0
+	result = strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add blackhole 10.0.0.0/8 metric 10 table 200")
+
+	c.Assert(scopeName(netlink.SCOPE_HOST), Equals, "host")
+	c.Assert(scopeName(netlink.Scope(100)), Equals, "100")
+}
+
+func (p *RouteSuite) TestRouteCtxCancelled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	oldRouteReplace, oldRouteDel := routeReplace, routeDel
+	defer func() { routeReplace, routeDel = oldRouteReplace, oldRouteDel }()
+
+	applied := 0
+	routeReplace = func(h *netlink.Handle, route *netlink.Route) error {
+		applied++
+		return nil
+	}
+	routeDel = func(h *netlink.Handle, route *netlink.Route) error {
+		applied++
+		return nil
+	}
+
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Type: unix.RTN_BLACKHOLE,
+	}
+
+	// The route is not touched if the context is done already
+	c.Assert(ReplaceRouteCtx(ctx, r), Equals, context.Canceled)
+	c.Assert(DeleteRouteCtx(ctx, r), Equals, context.Canceled)
+	c.Assert(applied, Equals, 0)
+}
+
+func (p *RouteSuite) TestReplaceRouteAsync(c *C) {
+	var results []<-chan error
+	for i := 0; i < 10; i++ {
+		r := Route{
+			Prefix: net.IPNet{
+				IP:   net.IPv4(10, byte(i), 0, 0),
+				Mask: net.CIDRMask(16, 32),
+			},
+			Device: "nonexistent-dev",
+		}
+		results = append(results, ReplaceRouteAsync(r))
+	}
+
+	// The result is delivered exactly once before the channel is closed
+	for _, errChan := range results {
+		c.Assert(<-errChan, Not(IsNil))
+		_, ok := <-errChan
+		c.Assert(ok, Equals, false)
+	}
+}
+
+func (p *RouteSuite) TestTos(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Tos: 0x10,
+	}
+	c.Assert(r.getNetlinkRoute().Tos, Equals, 0x10)
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 tos 16 dev eth0")
+	result = strings.Join(r.ToIPDeleteCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route del 10.0.0.0/8 tos 16 dev eth0")
+
+	rt := r.getNetlinkRoute()
+	c.Assert(newRouteFromNetlink("eth0", &rt).Tos, Equals, 0x10)
+
+	// TOS is ignored for IPv6
+	r.Prefix = net.IPNet{
+		IP:   net.ParseIP("f00d::"),
+		Mask: net.CIDRMask(64, 128),
+	}
+	c.Assert(r.getNetlinkRoute().Tos, Equals, 0)
+	result = strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip -6 route add f00d::/64 dev eth0")
+}
+
+func (p *RouteSuite) TestAdvMSS(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		MTU:    1450,
+		AdvMSS: 1410,
+	}
+	rt := r.getNetlinkRoute()
+	c.Assert(rt.AdvMSS, Equals, 1410)
+	c.Assert(newRouteFromNetlink("eth0", &rt).AdvMSS, Equals, 1410)
+
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 mtu 1450 advmss 1410 dev eth0")
+}
+
+func (p *RouteSuite) TestAdvMSSOverhead(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		MTU:            1450,
+		MTUExplicit:    true,
+		AdvMSSOverhead: TCPOverheadIPv4,
+	}
+	rt := r.getNetlinkRoute()
+	c.Assert(rt.AdvMSS, Equals, 1410)
+
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 mtu 1450 advmss 1410 dev eth0")
+
+	// The segment size follows the MTU selected when installing the route
+	r.MTUExplicit = false
+	rt = r.getNetlinkRoute()
+	setRouteMTU(r, &rt)
+	c.Assert(rt.AdvMSS, Equals, mtu.GetRouteMTU()-TCPOverheadIPv4)
+
+	// An explicit AdvMSS takes precedence
+	r.AdvMSS = 1000
+	c.Assert(r.getNetlinkRoute().AdvMSS, Equals, 1000)
+
+	// Without an MTU, no segment size is derived
+	r = Route{Prefix: r.Prefix, AdvMSSOverhead: TCPOverheadIPv4}
+	c.Assert(r.getNetlinkRoute().AdvMSS, Equals, 0)
+
+	// The route matches the route installed with the selected MTU
+	desired := Route{Prefix: r.Prefix, Device: "eth0", MTU: 1500, AdvMSSOverhead: TCPOverheadIPv4}
+	installed := Route{Prefix: r.Prefix, Device: "eth0", MTU: mtu.GetRouteMTU(), AdvMSS: mtu.GetRouteMTU() - TCPOverheadIPv4}
+	c.Assert(desired.Matches(&installed), Equals, true)
+	toAdd, toDelete := DiffRoutes([]Route{desired}, []Route{installed})
+	c.Assert(toAdd, HasLen, 0)
+	c.Assert(toDelete, HasLen, 0)
+
+	installed.AdvMSS = 1000
+	c.Assert(desired.Matches(&installed), Equals, false)
+
+	// Routes through a tunnel account for the encapsulation as well
+	r = Route{Prefix: r.Prefix, MTU: 1500, MTUExplicit: true, AdvMSSOverhead: TCPOverheadIPv4 + mtu.TunnelOverhead}
+	c.Assert(r.getNetlinkRoute().AdvMSS, Equals, 1500-TCPOverheadIPv4-mtu.TunnelOverhead)
+}
+
+func (p *RouteSuite) TestMatchesMTU(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	// Only the MTU of the desired route is normalized
+	desired := Route{Prefix: *prefix, Device: "eth0", MTU: 1500}
+	installed := Route{Prefix: *prefix, Device: "eth0", MTU: 9000}
+	c.Assert(desired.Matches(&installed), Equals, false)
+	c.Assert(desired.Equal(&installed), Equals, false)
+	toAdd, toDelete := DiffRoutes([]Route{desired}, []Route{installed})
+	c.Assert(toAdd, HasLen, 1)
+	c.Assert(toDelete, HasLen, 1)
+
+	installed.MTU = mtu.GetRouteMTU()
+	c.Assert(desired.Matches(&installed), Equals, true)
+
+	// An explicit MTU is installed as is
+	desired.MTUExplicit = true
+	c.Assert(desired.Matches(&installed), Equals, mtu.GetRouteMTU() == 1500)
+	installed.MTU = 1500
+	c.Assert(desired.Matches(&installed), Equals, true)
+}
+
+func (p *RouteSuite) TestSetRouteMTU(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		MTU: 9000,
+	}
+
+	// A non-zero MTU is replaced with the route MTU
+	rt := r.getNetlinkRoute()
+	setRouteMTU(r, &rt)
+	c.Assert(rt.MTU, Equals, mtu.GetRouteMTU())
+
+	// An explicit MTU is used as is
+	r.MTUExplicit = true
+	rt = r.getNetlinkRoute()
+	setRouteMTU(r, &rt)
+	c.Assert(rt.MTU, Equals, 9000)
+
+	// A change of the MTU requires the installed route to be replaced
+	installed := r.getNetlinkRoute()
+	installed.MTU = 1500
+	c.Assert(routeSpecEqual(&installed, &rt), Equals, false)
+	installed.MTU = 9000
+	c.Assert(routeSpecEqual(&installed, &rt), Equals, true)
+}
+
+func (p *RouteSuite) TestString(c *C) {
+	nexthop := net.ParseIP("10.0.0.1")
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(24, 32),
+		},
+		Nexthop: &nexthop,
+		Device:  "cilium_host",
+		MTU:     1450,
+		Scope:   netlink.SCOPE_LINK,
+		Table:   200,
+	}
+	c.Assert(r.String(), Equals, "10.0.0.0/24 via 10.0.0.1 dev cilium_host mtu 1450 scope link table 200")
+	c.Assert(fmt.Sprintf("%s", &r), Equals, r.String())
+
+	r = Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("f00d::"),
+			Mask: net.CIDRMask(64, 128),
+		},
+		Type: unix.RTN_BLACKHOLE,
+	}
+	c.Assert(r.String(), Equals, "blackhole f00d::/64")
+
+	r = Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Device: "eth0",
+		Nexthops: []NexthopInfo{
+			{IP: net.ParseIP("192.168.0.2")},
+			{IP: net.ParseIP("192.168.1.2"), Device: "eth1", Weight: 3},
+		},
+	}
+	c.Assert(r.String(), Equals, "10.0.0.0/8 dev eth0 nexthop via 192.168.0.2 nexthop via 192.168.1.2 dev eth1 weight 3")
+}
+
+func (p *RouteSuite) TestCreateNexthopRoute(c *C) {
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 10}}
+	routerNet := ipAsIPNet(net.ParseIP("1.2.3.4"))
+
+	rt := createNexthopRoute(link, routerNet, 200, 0)
+	c.Assert(rt.LinkIndex, Equals, 10)
+	c.Assert(rt.Table, Equals, 200)
+	c.Assert(rt.Scope, Equals, netlink.SCOPE_LINK)
+
+	rt = createNexthopRoute(link, routerNet, 200, netlink.SCOPE_HOST)
+	c.Assert(rt.Scope, Equals, netlink.SCOPE_HOST)
+}
+
+func (p *RouteSuite) TestToIPCommandMultiPath(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthops: []NexthopInfo{
+			{IP: net.ParseIP("192.168.0.2")},
+			{IP: net.ParseIP("192.168.1.2"), Device: "eth1", Weight: 3},
+		},
+	}
+	result := strings.Join(r.ToIPCommand("eth0"), " ")
+	c.Assert(result, Equals, "ip route add 10.0.0.0/8 nexthop via 192.168.0.2 dev eth0 nexthop via 192.168.1.2 dev eth1 weight 3")
+}
+
+func (p *RouteSuite) TestReplaceRouteDryRunSpecial(c *C) {
+	r := Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Type: unix.RTN_UNREACHABLE,
+	}
+	commands, err := ReplaceRouteDryRun(r)
+	c.Assert(err, IsNil)
+	c.Assert(commands, DeepEquals, []string{"ip route add unreachable 10.0.0.0/8"})
+
+	r.Type = 0
+	r.Device = "nonexistent-dev"
+	_, err = ReplaceRouteDryRun(r)
+	c.Assert(err, Not(IsNil))
+}
+
+func (p *RouteSuite) TestDiffRoutes(c *C) {
+	_, prefix1, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("10.1.0.0/16")
+	c.Assert(err, IsNil)
+	_, prefix3, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+
+	desired := []Route{
+		{Device: "eth0", Prefix: *prefix1, Nexthop: parseIP("192.168.0.1")},
+		{Device: "eth0", Prefix: *prefix2, Nexthop: parseIP("192.168.0.1")},
+		{Device: "eth0", Prefix: *prefix3, Nexthop: parseIP("f00d::1"), Scope: netlink.SCOPE_LINK},
+	}
+
+	// Routes as reported by the kernel with explicit defaults
+	actual := []Route{
+		{Device: "eth0", Prefix: *prefix1, Nexthop: parseIP("192.168.0.1"), Table: unix.RT_TABLE_MAIN},
+		{Device: "eth0", Prefix: *prefix2, Nexthop: parseIP("192.168.0.2"), Table: unix.RT_TABLE_MAIN},
+		{Device: "eth0", Prefix: *prefix3, Nexthop: parseIP("f00d::1"), Priority: ip6DefaultPriority},
+	}
+
+	toAdd, toDelete := DiffRoutes(desired, actual)
+	c.Assert(len(toAdd), Equals, 1)
+	c.Assert(toAdd[0].Nexthop.String(), Equals, "192.168.0.1")
+	c.Assert(toAdd[0].Prefix.String(), Equals, prefix2.String())
+	c.Assert(len(toDelete), Equals, 1)
+	c.Assert(toDelete[0].Nexthop.String(), Equals, "192.168.0.2")
+
+	toAdd, toDelete = DiffRoutes(desired, desired)
+	c.Assert(toAdd, DeepEquals, []Route{})
+	c.Assert(toDelete, DeepEquals, []Route{})
+
+	// different device
+	other := desired[0]
+	other.Device = "eth1"
+	c.Assert(desired[0].Equal(&other), Equals, false)
+
+	// different priority
+	other = desired[0]
+	other.Priority = 10
+	c.Assert(desired[0].Equal(&other), Equals, false)
+}
+
+func (p *RouteSuite) TestFromPrefix(c *C) {
+	_, prefix, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+	_, from, err := net.ParseCIDR("beef::/64")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:     "eth0",
+		Prefix:     *prefix,
+		Nexthop:    parseIP("f00d::1"),
+		FromPrefix: from,
+	}
+
+	c.Assert(strings.Join(rt.ToIPCommand("eth0"), " "), Equals,
+		"ip -6 route add f00d::/64 from beef::/64 via f00d::1 dev eth0")
+
+	_, err = ReplaceRouteDryRun(rt)
+	c.Assert(err, Not(IsNil))
+}
+
+func (p *RouteSuite) TestEncap(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	rt := Route{
+		Device:  "eth0",
+		Prefix:  *prefix,
+		Nexthop: parseIP("192.168.0.1"),
+		Encap:   &netlink.MPLSEncap{Labels: []int{100, 200}},
+	}
+
+	c.Assert(strings.Join(rt.ToIPCommand("eth0"), " "), Equals,
+		"ip route add 10.0.0.0/8 encap mpls 100/200 via 192.168.0.1 dev eth0")
+
+	nlRoute := rt.getNetlinkRoute()
+	c.Assert(nlRoute.Encap, Equals, rt.Encap)
+
+	other := rt
+	other.Encap = &netlink.MPLSEncap{Labels: []int{100, 200}}
+	c.Assert(rt.Equal(&other), Equals, true)
+
+	other.Encap = &netlink.MPLSEncap{Labels: []int{100}}
+	c.Assert(rt.Equal(&other), Equals, false)
+
+	other.Encap = nil
+	c.Assert(rt.Equal(&other), Equals, false)
+}
+
+func (p *RouteSuite) TestDeleteRouteNotFound(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	rt := Route{Prefix: *prefix, Type: unix.RTN_BLACKHOLE}
+
+	oldRouteDel := routeDel
+	defer func() { routeDel = oldRouteDel }()
+
+	var delErr error
+	routeDel = func(h *netlink.Handle, route *netlink.Route) error {
+		return delErr
+	}
+
+	delErr = syscall.ESRCH
+	c.Assert(DeleteRoute(rt), IsNil)
+
+	delErr = syscall.ENOENT
+	c.Assert(DeleteRoute(rt), IsNil)
+
+	delErr = syscall.EPERM
+	c.Assert(DeleteRoute(rt), Equals, syscall.EPERM)
+
+	delErr = nil
+	c.Assert(DeleteRoute(rt), IsNil)
+}
+
+func (p *RouteSuite) TestValidate(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	rt := Route{Device: "eth0", Prefix: *prefix, Nexthop: parseIP("192.168.0.1")}
+	c.Assert(rt.Validate(), IsNil)
+
+	invalid := rt
+	invalid.Prefix = net.IPNet{}
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	invalid = rt
+	invalid.Nexthop = parseIP("f00d::1")
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	invalid = rt
+	invalid.Local = net.ParseIP("f00d::1")
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	invalid = rt
+	invalid.Device = ""
+	invalid.Nexthop = nil
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	valid := invalid
+	valid.LinkIndex = 1
+	c.Assert(valid.Validate(), IsNil)
+
+	// The kernel resolves the device of gateway only routes
+	valid = invalid
+	valid.Nexthop = parseIP("192.168.0.1")
+	c.Assert(valid.Validate(), IsNil)
+
+	valid = invalid
+	valid.Nexthop = nil
+	valid.Type = unix.RTN_BLACKHOLE
+	c.Assert(valid.Validate(), IsNil)
+
+	valid = invalid
+	valid.Nexthop = nil
+	valid.Nexthops = []NexthopInfo{{IP: net.ParseIP("192.168.0.1"), Device: "eth0"}}
+	c.Assert(valid.Validate(), IsNil)
+
+	invalid = valid
+	invalid.Nexthops = []NexthopInfo{{IP: net.ParseIP("192.168.0.1")}}
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	invalid = valid
+	invalid.Nexthops = []NexthopInfo{{IP: net.ParseIP("f00d::1"), Device: "eth0"}}
+	c.Assert(invalid.Validate(), Not(IsNil))
+}
+
+func (p *RouteSuite) TestDefaultRoute(c *C) {
+	rt := DefaultRoute(net.ParseIP("192.168.0.1"), "eth0")
+	c.Assert(rt.Prefix.String(), Equals, "0.0.0.0/0")
+	c.Assert(rt.Nexthop.String(), Equals, "192.168.0.1")
+	c.Assert(rt.Device, Equals, "eth0")
+	c.Assert(rt.Validate(), IsNil)
+	c.Assert(strings.Join(rt.ToIPCommand("eth0"), " "), Equals,
+		"ip route add 0.0.0.0/0 via 192.168.0.1 dev eth0")
+
+	rt = DefaultRoute(net.ParseIP("f00d::1"), "eth0")
+	c.Assert(rt.Prefix.String(), Equals, "::/0")
+	c.Assert(rt.Nexthop.String(), Equals, "f00d::1")
+	c.Assert(rt.Validate(), IsNil)
+}
+
+func (p *RouteSuite) TestPrefixesOverlap(c *C) {
+	parse := func(s string) *net.IPNet {
+		_, prefix, err := net.ParseCIDR(s)
+		c.Assert(err, IsNil)
+		return prefix
+	}
+
+	c.Assert(prefixesOverlap(parse("10.0.0.0/8"), parse("10.1.0.0/16")), Equals, true)
+	c.Assert(prefixesOverlap(parse("10.1.0.0/16"), parse("10.0.0.0/8")), Equals, true)
+	c.Assert(prefixesOverlap(parse("10.1.0.0/16"), parse("10.1.0.0/16")), Equals, true)
+	c.Assert(prefixesOverlap(parse("10.1.0.0/16"), parse("10.2.0.0/16")), Equals, false)
+	c.Assert(prefixesOverlap(parse("0.0.0.0/0"), parse("10.2.0.0/16")), Equals, true)
+	c.Assert(prefixesOverlap(parse("f00d::/64"), parse("f00d::1/128")), Equals, true)
+	c.Assert(prefixesOverlap(parse("::/0"), parse("10.2.0.0/16")), Equals, false)
+}
+
+func (p *RouteSuite) TestReplaceWithRetry(c *C) {
+	oldRouteReplace := routeReplace
+	defer func() { routeReplace = oldRouteReplace }()
+
+	var (
+		attempts int
+		errs     []error
+	)
+	routeReplace = func(h *netlink.Handle, route *netlink.Route) error {
+		err := errs[attempts]
+		attempts++
+		return err
+	}
+
+	// Transient errors are retried
+	attempts, errs = 0, []error{unix.EBUSY, unix.EAGAIN, nil}
+	c.Assert(replaceWithRetry(defaultHandle, &netlink.Route{}), IsNil)
+	c.Assert(attempts, Equals, 3)
+
+	// Permanent errors are not retried
+	attempts, errs = 0, []error{unix.EINVAL, nil}
+	c.Assert(replaceWithRetry(defaultHandle, &netlink.Route{}), Equals, unix.EINVAL)
+	c.Assert(attempts, Equals, 1)
+
+	// The error of the last attempt is returned
+	attempts, errs = 0, []error{unix.EBUSY, unix.EBUSY, unix.EBUSY, nil}
+	c.Assert(replaceWithRetry(defaultHandle, &netlink.Route{}), Equals, unix.EBUSY)
+	c.Assert(attempts, Equals, ReplaceMaxAttempts)
+}
+
+func (p *RouteSuite) TestRouteJournalRollback(c *C) {
+	_, prefix1, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("10.1.0.0/16")
+	c.Assert(err, IsNil)
+
+	oldRouteReplace, oldRouteDel, oldNeighDel := routeReplace, routeDel, neighDel
+	defer func() { routeReplace, routeDel, neighDel = oldRouteReplace, oldRouteDel, oldNeighDel }()
+
+	var ops []string
+	routeReplace = func(h *netlink.Handle, route *netlink.Route) error {
+		ops = append(ops, "replace "+route.Dst.String())
+		return nil
+	}
+	routeDel = func(h *netlink.Handle, route *netlink.Route) error {
+		ops = append(ops, "del "+route.Dst.String())
+		return nil
+	}
+	neighDel = func(h *netlink.Handle, neigh *netlink.Neigh) error {
+		ops = append(ops, "neigh del "+neigh.IP.String())
+		return nil
+	}
+
+	mac, err := net.ParseMAC("02:00:00:00:00:01")
+	c.Assert(err, IsNil)
+	neighbor := Neighbor{IP: net.ParseIP("192.168.0.1"), MAC: mac, Device: "eth0"}
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 2}}
+
+	// A nil journal records nothing
+	var nilJournal *routeJournal
+	nilJournal.addNexthopRoute(&netlink.Route{Dst: ipAsIPNet(net.ParseIP("192.168.0.1"))})
+	nilJournal.addNeighbor(neighbor.getNetlinkNeigh(link))
+
+	// The route with a nexthop MAC added the L2 nexthop route and the
+	// neighbor entry of the nexthop
+	journal := &routeJournal{}
+	journal.addNexthopRoute(&netlink.Route{Dst: ipAsIPNet(net.ParseIP("192.168.0.1"))})
+	journal.addNeighbor(neighbor.getNetlinkNeigh(link))
+	journal.addRoute(Route{Prefix: *prefix1, Type: unix.RTN_BLACKHOLE}, nil)
+	journal.addRoute(Route{Prefix: *prefix2, Type: unix.RTN_BLACKHOLE}, &netlink.Route{Dst: prefix2})
+
+	// Replaced routes are restored, added routes, L2 nexthop routes and
+	// neighbor entries are removed
+	c.Assert(journal.rollback(defaultHandle), HasLen, 0)
+	c.Assert(ops, DeepEquals, []string{
+		"replace 10.1.0.0/16",
+		"del 10.0.0.0/8",
+		"del 192.168.0.1/32",
+		"neigh del 192.168.0.1",
+	})
+
+	// Routes and neighbor entries which are gone already are not an error
+	routeDel = func(h *netlink.Handle, route *netlink.Route) error {
+		return unix.ESRCH
+	}
+	neighDel = func(h *netlink.Handle, neigh *netlink.Neigh) error {
+		return unix.ENOENT
+	}
+	c.Assert(journal.rollback(defaultHandle), HasLen, 0)
+
+	routeDel = func(h *netlink.Handle, route *netlink.Route) error {
+		return unix.EPERM
+	}
+	neighDel = func(h *netlink.Handle, neigh *netlink.Neigh) error {
+		return unix.EPERM
+	}
+	c.Assert(journal.rollback(defaultHandle), HasLen, 3)
+}
+
+func (p *RouteSuite) TestGatewayOnlyRoute(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	rt := Route{Prefix: *prefix, Nexthop: parseIP("192.168.0.1")}
+	c.Assert(rt.isGatewayOnly(), Equals, true)
+
+	commands, err := ReplaceRouteDryRun(rt)
+	c.Assert(err, IsNil)
+	c.Assert(commands, DeepEquals, []string{"ip route add 10.0.0.0/8 via 192.168.0.1"})
+
+	rt.Device = "eth0"
+	c.Assert(rt.isGatewayOnly(), Equals, false)
+
+	// A zero interface index matches any device
+	a := netlink.Route{Dst: prefix, Gw: net.ParseIP("192.168.0.1"), LinkIndex: 2}
+	b := netlink.Route{Dst: prefix, Gw: net.ParseIP("192.168.0.1")}
+	c.Assert(routeSpecEqual(&a, &b), Equals, true)
+	b.LinkIndex = 3
+	c.Assert(routeSpecEqual(&a, &b), Equals, false)
+}
+
+func (p *RouteSuite) TestValidateNexthopMAC(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	mac, err := net.ParseMAC("02:00:00:00:00:01")
+	c.Assert(err, IsNil)
+
+	rt := Route{Device: "eth0", Prefix: *prefix, Nexthop: parseIP("192.168.0.1"), NexthopMAC: mac}
+	c.Assert(rt.Validate(), IsNil)
+
+	invalid := rt
+	invalid.Device = ""
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	invalid = rt
+	invalid.Nexthop = nil
+	c.Assert(invalid.Validate(), Not(IsNil))
+
+	// Neighbor entries of loopback and point-to-point devices cannot be
+	// looked up by IP address
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagBroadcast}}
+	c.Assert(rt.checkNexthopMAC(link), IsNil)
+	link.Flags = net.FlagLoopback
+	c.Assert(rt.checkNexthopMAC(link), Not(IsNil))
+	link.Flags = net.FlagPointToPoint
+	c.Assert(rt.checkNexthopMAC(link), Not(IsNil))
+
+	rt.NexthopMAC = nil
+	c.Assert(rt.checkNexthopMAC(link), IsNil)
+}
+
+func (p *RouteSuite) TestMatch(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: 2}}
+	routes := []netlink.Route{
+		{Dst: prefix, Gw: net.ParseIP("192.168.0.1"), LinkIndex: 3, Table: unix.RT_TABLE_MAIN},
+		{Dst: prefix, Gw: net.ParseIP("192.168.0.1"), LinkIndex: 2, Table: 200},
+		{Dst: prefix, Gw: net.ParseIP("192.168.0.1"), LinkIndex: 2, Table: unix.RT_TABLE_MAIN},
+	}
+
+	spec := netlink.Route{Dst: prefix, Gw: net.ParseIP("192.168.0.1"), LinkIndex: 2}
+	c.Assert(match(routes, link, &spec), Equals, &routes[2])
+
+	spec.Table = 200
+	c.Assert(match(routes, link, &spec), Equals, &routes[1])
+
+	spec.Table = 300
+	c.Assert(match(routes, link, &spec), IsNil)
+
+	// Without a link, routes of all devices are considered
+	spec = netlink.Route{Dst: prefix, Gw: net.ParseIP("192.168.0.1")}
+	c.Assert(match(routes, nil, &spec), Equals, &routes[0])
+}
+
+func (p *RouteSuite) TestMatchPrevious(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	routes := []netlink.Route{
+		{Dst: prefix, Table: 200, Gw: net.ParseIP("192.168.0.1")},
+		{Dst: prefix, Table: unix.RT_TABLE_MAIN, Priority: 10, Gw: net.ParseIP("192.168.0.2")},
+		{Dst: prefix, Table: unix.RT_TABLE_MAIN, Gw: net.ParseIP("192.168.0.3")},
+	}
+
+	// The route is identified by prefix, table, priority and TOS
+	previous := matchPrevious(routes, &netlink.Route{Dst: prefix, Gw: net.ParseIP("192.168.0.4")})
+	c.Assert(previous, Not(IsNil))
+	c.Assert(previous.Gw.String(), Equals, "192.168.0.3")
+
+	previous = matchPrevious(routes, &netlink.Route{Dst: prefix, Table: 200})
+	c.Assert(previous, Not(IsNil))
+	c.Assert(previous.Gw.String(), Equals, "192.168.0.1")
+
+	c.Assert(matchPrevious(routes, &netlink.Route{Dst: prefix, Tos: 0x10}), IsNil)
+	c.Assert(matchPrevious(routes, &netlink.Route{Dst: prefix, Table: 300}), IsNil)
+}
+
+func (p *RouteSuite) TestStrictSrc(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	a := Route{Prefix: *prefix, Device: "eth0", Local: net.ParseIP("10.0.0.1")}
+	b := Route{Prefix: *prefix, Device: "eth0", Local: net.ParseIP("10.0.0.2")}
+
+	// The preferred source address is ignored by default
+	c.Assert(a.Equal(&b), Equals, true)
+
+	b.StrictSrc = true
+	c.Assert(a.Equal(&b), Equals, false)
+	c.Assert(b.Equal(&a), Equals, false)
+
+	installed := b.getNetlinkRoute()
+	c.Assert(b.srcMatches(&installed), Equals, true)
+	installed = a.getNetlinkRoute()
+	c.Assert(a.srcMatches(&installed), Equals, true)
+	c.Assert(b.srcMatches(&installed), Equals, false)
+}
+
+func (p *RouteSuite) TestRouteSpecDiff(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+
+	previous := netlink.Route{
+		Dst:      prefix,
+		Gw:       net.ParseIP("192.168.0.1"),
+		MTU:      1500,
+		Scope:    netlink.SCOPE_UNIVERSE,
+		Priority: 0,
+	}
+	routeSpec := previous
+	c.Assert(routeSpecDiff(&previous, &routeSpec), HasLen, 0)
+
+	routeSpec.Gw = net.ParseIP("192.168.0.2")
+	routeSpec.MTU = 1450
+	routeSpec.Scope = netlink.SCOPE_LINK
+	c.Assert(routeSpecDiff(&previous, &routeSpec), DeepEquals, logrus.Fields{
+		"gw":    "192.168.0.1 -> 192.168.0.2",
+		"mtu":   "1500 -> 1450",
+		"scope": "global -> link",
+	})
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Rule is a policy routing rule which directs packets matching the selector
+// into a routing table
+type Rule struct {
+	// Priority is the priority of the rule, lower values are evaluated
+	// first
+	Priority int
+	// Mark is the firewall mark to match on. If 0, the mark is ignored.
+	Mark int
+	// Mask is the mask applied to the firewall mark before matching. If
+	// 0, the entire mark is matched.
+	Mask int
+	// Table is the routing table to look up if the rule matches
+	Table int
+	// From is the source prefix to match on. If nil, all sources match.
+	From *net.IPNet
+	// To is the destination prefix to match on. If nil, all destinations
+	// match.
+	To *net.IPNet
+}
+
+func (r *Rule) getLogger() *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"priority": r.Priority,
+		"mark":     r.Mark,
+		"mask":     r.Mask,
+		"table":    r.Table,
+		"from":     r.From,
+		"to":       r.To,
+	})
+}
+
+// getFamily returns the address family of the rule. Rules which do not
+// specify a prefix are considered IPv4 rules.
+func (r *Rule) getFamily() int {
+	switch {
+	case r.From != nil:
+		return ipFamily(r.From.IP)
+	case r.To != nil:
+		return ipFamily(r.To.IP)
+	default:
+		return netlink.FAMILY_V4
+	}
+}
+
+// getNetlinkRule returns the rule configuration as netlink.Rule
+func (r *Rule) getNetlinkRule() *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Family = r.getFamily()
+	rule.Priority = r.Priority
+	rule.Table = r.Table
+	rule.Src = r.From
+	rule.Dst = r.To
+
+	if r.Mark != 0 {
+		rule.Mark = r.Mark
+		if r.Mask != 0 {
+			rule.Mask = r.Mask
+		}
+	}
+
+	return rule
+}
+
+// ToIPCommand converts the rule into a full "ip rule ..." command
+func (r *Rule) ToIPCommand() []string {
+	res := []string{"ip"}
+	if r.getFamily() == netlink.FAMILY_V6 {
+		res = append(res, "-6")
+	}
+	res = append(res, "rule", "add", "pref", fmt.Sprintf("%d", r.Priority))
+	if r.Mark != 0 {
+		mark := fmt.Sprintf("0x%x", r.Mark)
+		if r.Mask != 0 {
+			mark += fmt.Sprintf("/0x%x", r.Mask)
+		}
+		res = append(res, "fwmark", mark)
+	}
+	res = append(res, "lookup", fmt.Sprintf("%d", r.Table))
+	if r.From != nil {
+		res = append(res, "from", r.From.String())
+	}
+	if r.To != nil {
+		res = append(res, "to", r.To.String())
+	}
+	return res
+}
+
+// lookupRule finds a rule with the same priority, mark and table as the
+// specified rule
+func lookupRule(rule *netlink.Rule) *netlink.Rule {
+	rules, err := netlink.RuleList(rule.Family)
+	if err != nil {
+		return nil
+	}
+
+	for _, r := range rules {
+		if r.Priority == rule.Priority && r.Mark == rule.Mark &&
+			r.Table == rule.Table {
+			return &r
+		}
+	}
+
+	return nil
+}
+
+func replaceRule(rule Rule) (bool, error) {
+	ruleSpec := rule.getNetlinkRule()
+	if lookupRule(ruleSpec) == nil {
+		if err := netlink.RuleAdd(ruleSpec); err != nil {
+			return false, fmt.Errorf("unable to add rule: %s", err)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// ReplaceRule adds the specified rule if it does not exist yet
+func ReplaceRule(rule Rule) error {
+	added, err := replaceRule(rule)
+	if err != nil {
+		rule.getLogger().WithError(err).Error("Unable to add rule")
+		return err
+	} else if added {
+		rule.getLogger().Info("Added rule")
+	}
+
+	return nil
+}
+
+// DeleteRule removes a rule. Deleting a rule which does not exist is not
+// considered an error.
+func DeleteRule(rule Rule) error {
+	if err := netlink.RuleDel(rule.getNetlinkRule()); err != nil {
+		if isNotFound(err) {
+			rule.getLogger().Debug("Rule to delete does not exist")
+			return nil
+		}
+
+		rule.getLogger().WithError(err).Error("Unable to delete rule")
+		return err
+	}
+
+	rule.getLogger().Info("Deleted rule")
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	. "gopkg.in/check.v1"
+)
+
+func (p *RouteSuite) TestGetNetlinkRule(c *C) {
+	r := Rule{
+		Priority: 100,
+		Mark:     0x200,
+		Table:    200,
+	}
+	rule := r.getNetlinkRule()
+	c.Assert(rule.Family, Equals, netlink.FAMILY_V4)
+	c.Assert(rule.Priority, Equals, 100)
+	c.Assert(rule.Mark, Equals, 0x200)
+	c.Assert(rule.Mask, Equals, -1)
+	c.Assert(rule.Table, Equals, 200)
+
+	r.Mask = 0xf00
+	c.Assert(r.getNetlinkRule().Mask, Equals, 0xf00)
+
+	_, from, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+	r = Rule{Priority: 100, Table: 200, From: from}
+	rule = r.getNetlinkRule()
+	c.Assert(rule.Family, Equals, netlink.FAMILY_V6)
+	c.Assert(rule.Mark, Equals, -1)
+	c.Assert(rule.Src, Equals, from)
+}
+
+func (p *RouteSuite) TestRuleToIPCommand(c *C) {
+	r := Rule{
+		Priority: 100,
+		Mark:     0x200,
+		Mask:     0xf00,
+		Table:    200,
+	}
+	result := strings.Join(r.ToIPCommand(), " ")
+	c.Assert(result, Equals, "ip rule add pref 100 fwmark 0x200/0xf00 lookup 200")
+
+	r.Mask = 0
+	result = strings.Join(r.ToIPCommand(), " ")
+	c.Assert(result, Equals, "ip rule add pref 100 fwmark 0x200 lookup 200")
+
+	_, from, err := net.ParseCIDR("f00d::/64")
+	c.Assert(err, IsNil)
+	_, to, err := net.ParseCIDR("f00d:1::/64")
+	c.Assert(err, IsNil)
+	r = Rule{Priority: 100, Table: 200, From: from, To: to}
+	result = strings.Join(r.ToIPCommand(), " ")
+	c.Assert(result, Equals, "ip -6 rule add pref 100 lookup 200 from f00d::/64 to f00d:1::/64")
+}
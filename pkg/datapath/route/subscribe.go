@@ -0,0 +1,395 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cilium/cilium/pkg/backoff"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// routeSubscription is a single netlink subscription to route updates
+type routeSubscription struct {
+	device  string
+	updates chan netlink.RouteUpdate
+	done    chan struct{}
+}
+
+// newRouteSubscription subscribes to route updates of all devices
+func newRouteSubscription(device string) (*routeSubscription, error) {
+	s := &routeSubscription{
+		device:  device,
+		updates: make(chan netlink.RouteUpdate),
+		done:    make(chan struct{}),
+	}
+
+	err := netlink.RouteSubscribeWithOptions(s.updates, s.done, netlink.RouteSubscribeOptions{
+		ErrorCallback: s.onError,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// onError is called by netlink when the subscription terminates due to an
+// error. Closing the subscription closes the socket which fails the pending
+// receive, such errors are expected and ignored.
+func (s *routeSubscription) onError(err error) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	log.WithError(err).WithField(logfields.Interface, s.device).
+		Warning("Route subscription failed")
+}
+
+// close closes the subscription. Pending updates are discarded.
+func (s *routeSubscription) close() {
+	close(s.done)
+
+	// The netlink subscription closes the updates channel after the socket
+	// has been closed, drain it to not block the sender
+	go func() {
+		for range s.updates {
+		}
+	}()
+}
+
+// newSubscriptionBackoff returns the backoff used to re-establish a route
+// subscription. A new backoff is used for each outage so that the delay
+// starts at the minimum again.
+func newSubscriptionBackoff(name string) *backoff.Exponential {
+	return &backoff.Exponential{
+		Min:  time.Duration(100) * time.Millisecond,
+		Max:  time.Duration(10) * time.Second,
+		Name: name,
+	}
+}
+
+// waitBackoff waits for the next interval of boff. It returns false without
+// waiting for the interval to pass if ctx is done.
+func waitBackoff(ctx context.Context, boff *backoff.Exponential) bool {
+	timer := time.NewTimer(boff.Duration())
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// SubscribeRouteChanges subscribes to changes of routes which point to the
+// specified device. Added, changed and removed routes are all sent to the
+// returned channel, the caller is expected to verify the current state of a
+// route, e.g. with LookupRoute(). If the subscription fails due to netlink
+// errors, it is re-established with an exponential backoff. The channel is
+// closed when ctx is cancelled.
+func SubscribeRouteChanges(ctx context.Context, device string) (<-chan Route, error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup interface %s: %s", device, err)
+	}
+	linkIndex := link.Attrs().Index
+
+	sub, err := newRouteSubscription(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to subscribe to route changes: %s", err)
+	}
+
+	routes := make(chan Route)
+
+	go func() {
+		defer close(routes)
+		defer func() {
+			if sub != nil {
+				sub.close()
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case update, ok := <-sub.updates:
+				if !ok {
+					// The subscription has terminated due to an
+					// error, re-establish it
+					sub.close()
+					sub = nil
+					boff := newSubscriptionBackoff("route-subscription-" + device)
+					for sub == nil {
+						if !waitBackoff(ctx, boff) {
+							return
+						}
+
+						sub, err = newRouteSubscription(device)
+						if err != nil {
+							log.WithError(err).WithField(logfields.Interface, device).
+								Warning("Unable to re-establish route subscription")
+						}
+					}
+					continue
+				}
+
+				installedRoutes.update(&update.Route)
+
+				if update.LinkIndex != linkIndex {
+					continue
+				}
+
+				select {
+				case routes <- newRouteFromNetlink(device, &update.Route):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return routes, nil
+}
+
+// RouteEvent is a change of a route observed by a RouteWatcher
+type RouteEvent struct {
+	// Route is the added, changed or removed route
+	Route Route
+	// Deleted is true if the route has been removed
+	Deleted bool
+}
+
+// RouteWatcher watches the routes which point to a device. Unlike
+// SubscribeRouteChanges, events are buffered so that a slow reader does not
+// cause the netlink subscription to overflow. When the subscription has to be
+// re-established, the routes of the device are listed again and the
+// differences to the last known state are sent as events so no change is
+// permanently lost.
+type RouteWatcher struct {
+	device    string
+	linkIndex int
+	events    chan RouteEvent
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	// known is the last known state of the routes of the device. It is
+	// only accessed by the watcher goroutine.
+	known map[string]Route
+}
+
+// routeKey returns the key identifying a route in the kernel, routes with
+// the same key replace each other
+func routeKey(route *Route) string {
+	return fmt.Sprintf("%s/%d", cacheKey(route.Prefix, route.Table), getPriority(&route.Prefix, route.Priority))
+}
+
+// NewRouteWatcher starts watching the routes which point to the specified
+// device. The watcher must be stopped with Close().
+func NewRouteWatcher(device string) (*RouteWatcher, error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup interface %s: %s", device, err)
+	}
+
+	sub, err := newRouteSubscription(device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to subscribe to route changes: %s", err)
+	}
+
+	// The routes are listed after subscribing to not miss any change in
+	// between
+	routes, err := ListRoutes(device, netlink.FAMILY_ALL)
+	if err != nil {
+		sub.close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &RouteWatcher{
+		device:    device,
+		linkIndex: link.Attrs().Index,
+		events:    make(chan RouteEvent),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		known:     map[string]Route{},
+	}
+
+	for i := range routes {
+		w.known[routeKey(&routes[i])] = routes[i]
+	}
+
+	go w.run(ctx, sub)
+
+	return w, nil
+}
+
+// Events returns the channel on which route events are delivered. The
+// channel is closed when the watcher is stopped.
+func (w *RouteWatcher) Events() <-chan RouteEvent {
+	return w.events
+}
+
+// Close stops the watcher. Events which have not been delivered yet are
+// discarded.
+func (w *RouteWatcher) Close() {
+	w.cancel()
+	<-w.done
+}
+
+// track updates the last known state of the routes with event
+func (w *RouteWatcher) track(event RouteEvent) {
+	key := routeKey(&event.Route)
+	if event.Deleted {
+		delete(w.known, key)
+	} else {
+		w.known[key] = event.Route
+	}
+}
+
+// resync returns the events required to get from the last known state to the
+// specified routes and replaces the last known state. All routes are reported
+// as added as changes may have been missed.
+func (w *RouteWatcher) resync(routes []Route) []RouteEvent {
+	current := make(map[string]Route, len(routes))
+	for i := range routes {
+		current[routeKey(&routes[i])] = routes[i]
+	}
+
+	events := make([]RouteEvent, 0, len(routes))
+	for key, route := range w.known {
+		if _, ok := current[key]; !ok {
+			events = append(events, RouteEvent{Route: route, Deleted: true})
+		}
+	}
+
+	for i := range routes {
+		events = append(events, RouteEvent{Route: routes[i]})
+	}
+
+	w.known = current
+
+	return events
+}
+
+// resubscribe re-establishes the route subscription with an exponential
+// backoff. nil is returned if ctx is cancelled.
+func (w *RouteWatcher) resubscribe(ctx context.Context) *routeSubscription {
+	boff := newSubscriptionBackoff("route-watcher-" + w.device)
+	for {
+		if !waitBackoff(ctx, boff) {
+			return nil
+		}
+
+		sub, err := newRouteSubscription(w.device)
+		if err == nil {
+			return sub
+		}
+
+		log.WithError(err).WithField(logfields.Interface, w.device).
+			Warning("Unable to re-establish route subscription")
+	}
+}
+
+// listRoutes lists the routes of the device to catch up on changes missed
+// while the subscription was down. Listing is retried with an exponential
+// backoff until it succeeds. false is returned if ctx is cancelled.
+func (w *RouteWatcher) listRoutes(ctx context.Context) ([]Route, bool) {
+	boff := newSubscriptionBackoff("route-watcher-" + w.device)
+	for {
+		routes, err := ListRoutes(w.device, netlink.FAMILY_ALL)
+		if err == nil {
+			return routes, true
+		}
+
+		log.WithError(err).WithField(logfields.Interface, w.device).
+			Warning("Unable to resync routes after re-establishing route subscription")
+
+		if !waitBackoff(ctx, boff) {
+			return nil, false
+		}
+	}
+}
+
+func (w *RouteWatcher) run(ctx context.Context, sub *routeSubscription) {
+	defer close(w.done)
+	defer close(w.events)
+	defer func() {
+		if sub != nil {
+			sub.close()
+		}
+	}()
+
+	var queue []RouteEvent
+	for {
+		// Only attempt to deliver an event if one is pending
+		var out chan RouteEvent
+		var next RouteEvent
+		if len(queue) > 0 {
+			out = w.events
+			next = queue[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case out <- next:
+			queue = queue[1:]
+
+		case update, ok := <-sub.updates:
+			if !ok {
+				// The subscription has terminated due to an error,
+				// re-establish it and catch up on missed changes
+				sub.close()
+				sub = w.resubscribe(ctx)
+				if sub == nil {
+					return
+				}
+
+				routes, ok := w.listRoutes(ctx)
+				if !ok {
+					return
+				}
+
+				queue = append(queue, w.resync(routes)...)
+				continue
+			}
+
+			installedRoutes.update(&update.Route)
+
+			if update.LinkIndex != w.linkIndex {
+				continue
+			}
+
+			event := RouteEvent{
+				Route:   newRouteFromNetlink(w.device, &update.Route),
+				Deleted: update.Type == unix.RTM_DELROUTE,
+			}
+			w.track(event)
+			queue = append(queue, event)
+		}
+	}
+}
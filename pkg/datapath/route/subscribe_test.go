@@ -0,0 +1,116 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cilium/cilium/pkg/backoff"
+
+	. "gopkg.in/check.v1"
+)
+
+func (p *RouteSuite) TestSubscribeRouteChangesInvalidDevice(c *C) {
+	routes, err := SubscribeRouteChanges(context.Background(), "nonexistent-dev")
+	c.Assert(err, Not(IsNil))
+	c.Assert(routes, IsNil)
+}
+
+func (p *RouteSuite) TestRouteSubscriptionError(c *C) {
+	var buf bytes.Buffer
+	oldOut := log.Logger.Out
+	log.Logger.Out = &buf
+	defer func() { log.Logger.Out = oldOut }()
+
+	s := &routeSubscription{device: "eth0", done: make(chan struct{})}
+	s.onError(errors.New("receive failed"))
+	c.Assert(bytes.Contains(buf.Bytes(), []byte("Route subscription failed")), Equals, true)
+
+	// Errors caused by closing the subscription are not logged
+	buf.Reset()
+	close(s.done)
+	s.onError(errors.New("receive failed"))
+	c.Assert(buf.Len(), Equals, 0)
+}
+
+func (p *RouteSuite) TestRouteWatcherInvalidDevice(c *C) {
+	w, err := NewRouteWatcher("nonexistent-dev")
+	c.Assert(err, Not(IsNil))
+	c.Assert(w, IsNil)
+}
+
+func (p *RouteSuite) TestRouteWatcherResync(c *C) {
+	_, prefix1, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	_, prefix2, err := net.ParseCIDR("10.1.0.0/16")
+	c.Assert(err, IsNil)
+
+	removed := Route{Prefix: *prefix1, Device: "eth0"}
+	kept := Route{Prefix: *prefix2, Device: "eth0"}
+	w := &RouteWatcher{
+		known: map[string]Route{
+			routeKey(&removed): removed,
+			routeKey(&kept):    kept,
+		},
+	}
+
+	// Routes with a different table or priority are distinct
+	added := Route{Prefix: *prefix1, Device: "eth0", Table: 200}
+	c.Assert(routeKey(&added), Not(Equals), routeKey(&removed))
+
+	events := w.resync([]Route{kept, added})
+	c.Assert(events, HasLen, 3)
+	c.Assert(events[0].Deleted, Equals, true)
+	c.Assert(events[0].Route.Equal(&removed), Equals, true)
+	c.Assert(events[1], DeepEquals, RouteEvent{Route: kept})
+	c.Assert(events[2], DeepEquals, RouteEvent{Route: added})
+	c.Assert(w.known, HasLen, 2)
+
+	w.track(RouteEvent{Route: kept, Deleted: true})
+	c.Assert(w.known, HasLen, 1)
+	w.track(RouteEvent{Route: removed})
+	c.Assert(w.known, HasLen, 2)
+}
+
+func (p *RouteSuite) TestRouteWatcherListRoutes(c *C) {
+	w := &RouteWatcher{device: "lo"}
+	_, ok := w.listRoutes(context.Background())
+	c.Assert(ok, Equals, true)
+
+	// Listing is retried until the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = &RouteWatcher{device: "nonexistent-dev"}
+	routes, ok := w.listRoutes(ctx)
+	c.Assert(ok, Equals, false)
+	c.Assert(routes, IsNil)
+}
+
+func (p *RouteSuite) TestWaitBackoff(c *C) {
+	boff := newSubscriptionBackoff("route-subscription-test")
+	c.Assert(waitBackoff(context.Background(), boff), Equals, true)
+
+	// Waiting stops as soon as the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	boff = &backoff.Exponential{Min: time.Hour}
+	start := time.Now()
+	c.Assert(waitBackoff(ctx, boff), Equals, false)
+	c.Assert(time.Since(start) < time.Minute, Equals, true)
+}